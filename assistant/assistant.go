@@ -2,8 +2,11 @@ package assistant
 
 import (
 	"crypto/tls"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -39,10 +42,31 @@ func NewAssistant(apiUrl, username, password, org, space string, skipSSLValidati
 	}
 }
 
+type cfFailure string
+
+// asUser runs actions as the assistant's user. The cf helpers assert with
+// gomega, so their failures are turned into an error instead of a panic.
+func (a *Assistant) asUser(actions func()) (err error) {
+	gomega.RegisterFailHandler(func(message string, _ ...int) {
+		panic(cfFailure(message))
+	})
+	defer func() {
+		if r := recover(); r != nil {
+			failure, ok := r.(cfFailure)
+			if !ok {
+				panic(r)
+			}
+			err = errors.New(string(failure))
+		}
+	}()
+	cf.AsUser(a.userContext, actions)
+	return nil
+}
+
 func (a *Assistant) AppGuid(appName string) (string, error) {
 	var appGuid string
 	var err error
-	cf.AsUser(a.userContext, func() {
+	if cfErr := a.asUser(func() {
 		session := cf.Cf("app", appName, "--guid").Wait(CF_TIMEOUT)
 		if session.ExitCode() != 0 {
 			err = errors.New(fmt.Sprintf("cf app --guid command failed: %s", string(session.Out.Contents())))
@@ -50,18 +74,98 @@ func (a *Assistant) AppGuid(appName string) (string, error) {
 		}
 
 		appGuid = strings.TrimSpace(string(session.Out.Contents()))
-	})
+	}); cfErr != nil {
+		err = cfErr
+	}
 	return appGuid, err
 }
 
+func (a *Assistant) AppInstances(appGuid string) ([]int, error) {
+	var indices []int
+	var err error
+	if cfErr := a.asUser(func() {
+		session := cf.Cf("curl", "/v2/apps/"+appGuid+"/stats").Wait(CF_TIMEOUT)
+		if session.ExitCode() != 0 {
+			err = errors.New(fmt.Sprintf("cf curl stats command failed: %s", string(session.Out.Contents())))
+			return
+		}
+
+		indices, err = ParseRunningInstances(session.Out.Contents())
+	}); cfErr != nil {
+		err = cfErr
+	}
+	return indices, err
+}
+
+// ParseRunningInstances reads the indices of running instances from the
+// /v2/apps/:guid/stats response.
+func ParseRunningInstances(stats []byte) ([]int, error) {
+	instances := map[string]struct {
+		State string `json:"state"`
+	}{}
+	if err := json.Unmarshal(stats, &instances); err != nil {
+		return nil, err
+	}
+
+	indices := []int{}
+	for index, instance := range instances {
+		i, err := strconv.Atoi(index)
+		if err != nil || instance.State != "RUNNING" {
+			continue
+		}
+		indices = append(indices, i)
+	}
+	sort.Ints(indices)
+	return indices, nil
+}
+
 func (a *Assistant) AppUrl(appName string) string {
-	var appUrl string
-	cf.AsUser(a.userContext, func() {
-		session := runner.Run("bash", "-c", `cf app `+appName+` | grep urls | cut -d" " -f2`)
-		bytes := session.Wait(CF_TIMEOUT).Out.Contents()
-		appUrl = strings.TrimSpace(string(bytes))
-	})
-	return appUrl
+	urls, err := a.AppUrls(appName)
+	if err != nil || len(urls) == 0 {
+		return ""
+	}
+	return urls[0]
+}
+
+func (a *Assistant) AppUrls(appName string) ([]string, error) {
+	var appUrls []string
+	var err error
+	if cfErr := a.asUser(func() {
+		session := cf.Cf("app", appName).Wait(CF_TIMEOUT)
+		if session.ExitCode() != 0 {
+			err = errors.New(fmt.Sprintf("cf app command failed: %s", string(session.Out.Contents())))
+			return
+		}
+
+		appUrls = ParseAppUrls(string(session.Out.Contents()))
+	}); cfErr != nil {
+		err = cfErr
+	}
+	return appUrls, err
+}
+
+// ParseAppUrls reads the routes from `cf app` output, which lists them on a
+// "urls:" line in older CLIs and a "routes:" line in newer ones.
+func ParseAppUrls(output string) []string {
+	urls := []string{}
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		var list string
+		if strings.HasPrefix(line, "urls:") {
+			list = strings.TrimPrefix(line, "urls:")
+		} else if strings.HasPrefix(line, "routes:") {
+			list = strings.TrimPrefix(line, "routes:")
+		} else {
+			continue
+		}
+		for _, url := range strings.Split(list, ",") {
+			if url = strings.TrimSpace(url); url != "" {
+				urls = append(urls, url)
+			}
+		}
+		break
+	}
+	return urls
 }
 
 func (a *Assistant) GetOauthToken() string {
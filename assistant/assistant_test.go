@@ -0,0 +1,47 @@
+package assistant_test
+
+import (
+	. "github.com/cloudfoundry-incubator/thoth/assistant"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ParseRunningInstances()", func() {
+	It("returns the sorted indices of running instances", func() {
+		stats := `{"2":{"state":"RUNNING"},"0":{"state":"RUNNING"},"1":{"state":"CRASHED"}}`
+		Expect(ParseRunningInstances([]byte(stats))).To(Equal([]int{0, 2}))
+	})
+
+	It("fails on invalid stats", func() {
+		_, err := ParseRunningInstances([]byte("not json"))
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+var _ = Describe("ParseAppUrls()", func() {
+	It("reads the urls line of older CLIs", func() {
+		output := "Showing health and status for app benchmarked-app in org o / space s as admin...\n" +
+			"OK\n\n" +
+			"requested state: started\n" +
+			"instances: 2/2\n" +
+			"usage: 64M x 2 instances\n" +
+			"urls: benchmarked-app.example.com, probe.example.com/path\n" +
+			"last uploaded: Tue Apr 19 10:00:00 UTC 2016\n"
+
+		Expect(ParseAppUrls(output)).To(Equal([]string{"benchmarked-app.example.com", "probe.example.com/path"}))
+	})
+
+	It("reads the routes line of newer CLIs", func() {
+		output := "name:              benchmarked-app\n" +
+			"requested state:   started\n" +
+			"routes:            benchmarked-app.example.com\n" +
+			"stack:             cflinuxfs2\n"
+
+		Expect(ParseAppUrls(output)).To(Equal([]string{"benchmarked-app.example.com"}))
+	})
+
+	It("returns no urls for apps without routes", func() {
+		Expect(ParseAppUrls("urls: \n")).To(BeEmpty())
+	})
+})
@@ -0,0 +1,154 @@
+package benchmark
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AccessLogRecord is a gorouter RTR access log line. The leading fields are
+// positional; after the client address comes the backend address on newer
+// gorouters, then key:value pairs whose values may be quoted.
+type AccessLogRecord struct {
+	Host           string
+	Timestamp      string
+	Method         string
+	Path           string
+	Protocol       string
+	StatusCode     int
+	BytesReceived  int64
+	BytesSent      int64
+	Referer        string
+	UserAgent      string
+	RemoteAddress  string
+	BackendAddress string
+
+	XForwardedFor   string
+	XForwardedProto string
+	VcapRequestId   string
+	AppId           string
+	AppIndex        string
+
+	ResponseTime    time.Duration
+	GorouterTime    time.Duration
+	AppTime         time.Duration
+	HasResponseTime bool
+	HasGorouterTime bool
+	HasAppTime      bool
+
+	Fields map[string]string
+}
+
+var accessLogKeyPattern = regexp.MustCompile(`^[a-z][a-z0-9_]*:`)
+
+// ParseAccessLogRecord parses the positional fields when the line starts like
+// an access log and the key:value fields wherever they appear.
+func ParseAccessLogRecord(line []byte) (AccessLogRecord, error) {
+	tokens := splitAccessLog(strings.TrimSpace(string(line)))
+	record := AccessLogRecord{Fields: map[string]string{}}
+
+	fields := tokens
+	if len(tokens) >= 10 && tokens[1] == "-" && strings.HasPrefix(tokens[2], "[") {
+		record.Host = tokens[0]
+		record.Timestamp = strings.TrimSuffix(strings.TrimPrefix(tokens[2], "["), "]")
+		record.Referer = unquote(tokens[7])
+		record.UserAgent = unquote(tokens[8])
+		record.RemoteAddress = unquote(tokens[9])
+
+		request := strings.Fields(unquote(tokens[3]))
+		if len(request) == 3 {
+			record.Method, record.Path, record.Protocol = request[0], request[1], request[2]
+		}
+
+		var err error
+		if record.StatusCode, err = strconv.Atoi(tokens[4]); err != nil {
+			return AccessLogRecord{}, errors.New("invalid status in access log: " + tokens[4])
+		}
+		record.BytesReceived, _ = strconv.ParseInt(tokens[5], 10, 64)
+		record.BytesSent, _ = strconv.ParseInt(tokens[6], 10, 64)
+
+		fields = tokens[10:]
+		if len(fields) > 0 && !accessLogKeyPattern.MatchString(fields[0]) {
+			record.BackendAddress = unquote(fields[0])
+			fields = fields[1:]
+		}
+	}
+
+	for _, token := range fields {
+		if accessLogKeyPattern.MatchString(token) {
+			kv := strings.SplitN(token, ":", 2)
+			record.Fields[kv[0]] = unquote(kv[1])
+		}
+	}
+	if record.StatusCode == 0 && len(record.Fields) == 0 {
+		return AccessLogRecord{}, errors.New("not a gorouter access log line")
+	}
+
+	record.XForwardedFor = record.Fields["x_forwarded_for"]
+	record.XForwardedProto = record.Fields["x_forwarded_proto"]
+	record.VcapRequestId = record.Fields["vcap_request_id"]
+	record.AppId = record.Fields["app_id"]
+	record.AppIndex = record.Fields["app_index"]
+	if record.AppIndex == "" {
+		record.AppIndex = record.Fields["index"]
+	}
+	record.ResponseTime, record.HasResponseTime = record.seconds("response_time")
+	record.GorouterTime, record.HasGorouterTime = record.seconds("gorouter_time")
+	record.AppTime, record.HasAppTime = record.seconds("app_time")
+
+	return record, nil
+}
+
+func (r AccessLogRecord) seconds(key string) (time.Duration, bool) {
+	value, ok := r.Fields[key]
+	if !ok {
+		return 0, false
+	}
+	duration, err := time.ParseDuration(value + "s")
+	if err != nil {
+		return 0, false
+	}
+	return duration, true
+}
+
+// splitAccessLog splits on spaces outside of quotes and brackets.
+func splitAccessLog(line string) []string {
+	tokens := []string{}
+	var current []byte
+	inQuote, inBracket, escaped := false, false, false
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case escaped:
+			escaped = false
+		case c == '\\' && inQuote:
+			escaped = true
+		case c == '"' && !inBracket:
+			inQuote = !inQuote
+		case c == '[' && !inQuote:
+			inBracket = true
+		case c == ']' && !inQuote:
+			inBracket = false
+		case c == ' ' && !inQuote && !inBracket:
+			if len(current) > 0 {
+				tokens = append(tokens, string(current))
+				current = nil
+			}
+			continue
+		}
+		current = append(current, c)
+	}
+	if len(current) > 0 {
+		tokens = append(tokens, string(current))
+	}
+	return tokens
+}
+
+func unquote(s string) string {
+	if len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"' {
+		return strings.Replace(s[1:len(s)-1], `\"`, `"`, -1)
+	}
+	return s
+}
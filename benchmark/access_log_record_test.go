@@ -0,0 +1,89 @@
+package benchmark_test
+
+import (
+	"time"
+
+	. "github.com/cloudfoundry-incubator/thoth/benchmark"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("AccessLogRecord", func() {
+	It("parses the original gorouter format", func() {
+		line := `benchmarked-app.example.com - [19/04/2016:10:00:00 +0000] "GET /abc.html HTTP/1.1" 200 0 5 "-" "Go-http-client/1.1" 10.0.2.15:51234 ` +
+			`x_forwarded_for:"10.0.2.15" x_forwarded_proto:"http" vcap_request_id:0a1b2c3d-4e5f-6071-8293-a4b5c6d7e8f9 response_time:0.003456 app_id:app-guid index:1`
+
+		record, err := ParseAccessLogRecord([]byte(line))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(record.Host).To(Equal("benchmarked-app.example.com"))
+		Expect(record.Timestamp).To(Equal("19/04/2016:10:00:00 +0000"))
+		Expect(record.Method).To(Equal("GET"))
+		Expect(record.Path).To(Equal("/abc.html"))
+		Expect(record.Protocol).To(Equal("HTTP/1.1"))
+		Expect(record.StatusCode).To(Equal(200))
+		Expect(record.BytesReceived).To(Equal(int64(0)))
+		Expect(record.BytesSent).To(Equal(int64(5)))
+		Expect(record.Referer).To(Equal("-"))
+		Expect(record.UserAgent).To(Equal("Go-http-client/1.1"))
+		Expect(record.RemoteAddress).To(Equal("10.0.2.15:51234"))
+		Expect(record.BackendAddress).To(BeEmpty())
+		Expect(record.XForwardedFor).To(Equal("10.0.2.15"))
+		Expect(record.XForwardedProto).To(Equal("http"))
+		Expect(record.VcapRequestId).To(Equal("0a1b2c3d-4e5f-6071-8293-a4b5c6d7e8f9"))
+		Expect(record.AppId).To(Equal("app-guid"))
+		Expect(record.AppIndex).To(Equal("1"))
+		Expect(record.HasResponseTime).To(BeTrue())
+		Expect(record.ResponseTime).To(Equal(3456 * time.Microsecond))
+		Expect(record.HasGorouterTime).To(BeFalse())
+	})
+
+	It("parses newer formats with a backend address, quoted values and router timings", func() {
+		line := `benchmarked-app.example.com - [2019-07-01T10:00:00.123+0000] "POST /api/abc HTTP/1.1" 201 12 2048 "-" "thoth \"probe\"" "10.0.2.15:51234" "10.0.16.5:61001" ` +
+			`x_forwarded_for:"10.0.2.15, 10.0.1.1" x_forwarded_proto:"https" vcap_request_id:"0a1b2c3d-4e5f-6071-8293-a4b5c6d7e8f9" response_time:0.012 gorouter_time:0.002 app_time:0.010 ` +
+			`app_id:"app-guid" app_index:"0" x_cf_routererror:"-" x_b3_traceid:"abc"`
+
+		record, err := ParseAccessLogRecord([]byte(line))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(record.Method).To(Equal("POST"))
+		Expect(record.StatusCode).To(Equal(201))
+		Expect(record.BytesReceived).To(Equal(int64(12)))
+		Expect(record.BytesSent).To(Equal(int64(2048)))
+		Expect(record.UserAgent).To(Equal(`thoth "probe"`))
+		Expect(record.RemoteAddress).To(Equal("10.0.2.15:51234"))
+		Expect(record.BackendAddress).To(Equal("10.0.16.5:61001"))
+		Expect(record.XForwardedFor).To(Equal("10.0.2.15, 10.0.1.1"))
+		Expect(record.AppIndex).To(Equal("0"))
+		Expect(record.ResponseTime).To(Equal(12 * time.Millisecond))
+		Expect(record.HasGorouterTime).To(BeTrue())
+		Expect(record.GorouterTime).To(Equal(2 * time.Millisecond))
+		Expect(record.HasAppTime).To(BeTrue())
+		Expect(record.AppTime).To(Equal(10 * time.Millisecond))
+		Expect(record.Fields).To(HaveKeyWithValue("x_b3_traceid", "abc"))
+	})
+
+	It("reads the key:value fields of partial lines", func() {
+		record, err := ParseAccessLogRecord([]byte("response_time:0.03 /abc.html"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(record.ResponseTime).To(Equal(30 * time.Millisecond))
+		Expect(record.StatusCode).To(BeZero())
+	})
+
+	It("parses fractional seconds exactly", func() {
+		record, err := ParseAccessLogRecord([]byte("response_time:0.035 gorouter_time:0.000123"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(record.ResponseTime).To(Equal(35 * time.Millisecond))
+		Expect(record.GorouterTime).To(Equal(123 * time.Microsecond))
+	})
+
+	It("ignores response times that are not numbers", func() {
+		record, err := ParseAccessLogRecord([]byte(`response_time:"-"`))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(record.HasResponseTime).To(BeFalse())
+	})
+
+	It("rejects lines that are not access logs", func() {
+		_, err := ParseAccessLogRecord([]byte("Created app with guid abc"))
+		Expect(err).To(HaveOccurred())
+	})
+})
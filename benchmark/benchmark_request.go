@@ -1,9 +1,11 @@
 package benchmark
 
 import (
-	"errors"
-	"net/http"
-	"regexp"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http/httptrace"
+	"strconv"
 	"strings"
 	"time"
 
@@ -11,103 +13,181 @@ import (
 	"github.com/google/uuid"
 )
 
+const DEFAULT_HTTP_TIMEOUT = 30 * time.Second
+
 type Clock interface {
 	Now() time.Time
 	Since(t time.Time) time.Duration
+	After(d time.Duration) <-chan time.Time
 }
 
 type BenchmarkRequest struct {
 	Guid          uuid.UUID
+	ScheduledAt   time.Time
+	HttpTimeout   time.Duration
 	httpStartStop events.HttpStartStop
 	logMessage    events.LogMessage
+	router        RouterMetadata
+	clientTiming  ClientTiming
+	requestId     string
+	bodyBytes     int64
+	timing        PartialTiming
+	scheduleDelay time.Duration
 
 	appUrl  string
+	shape   RequestShape
 	ch      <-chan *events.Envelope
 	clock   Clock
 	timeout time.Duration
 }
 
-func NewBenchmarkRequest(appUrl string, ch <-chan *events.Envelope, clock Clock, timeout time.Duration) (*BenchmarkRequest, error) {
+func NewBenchmarkRequest(appUrl string, shape RequestShape, ch <-chan *events.Envelope, clock Clock, timeout time.Duration) (*BenchmarkRequest, error) {
 	guuid, err := uuid.NewRandom()
 	if err != nil {
 		return nil, err
 	}
 	return &BenchmarkRequest{
-		Guid:    guuid,
-		appUrl:  appUrl,
-		ch:      ch,
-		clock:   clock,
-		timeout: timeout,
+		Guid:        guuid,
+		HttpTimeout: DEFAULT_HTTP_TIMEOUT,
+		appUrl:      appUrl,
+		shape:       shape,
+		ch:          ch,
+		clock:       clock,
+		timeout:     timeout,
 	}, nil
 }
 
 func (br *BenchmarkRequest) Do() (BenchmarkResponse, error) {
+	if err := br.Send(); err != nil {
+		return BenchmarkResponse{}, err
+	}
+	return br.Collect()
+}
+
+// Send makes the HTTP request and records its timing. The envelopes can then
+// be collected separately, so the caller need not wait on the firehose.
+func (br *BenchmarkRequest) Send() error {
 	timestamp := br.clock.Now()
-	timeForRequest, respCode := br.makeRequest()
-	err := br.grabMessages()
+	if !br.ScheduledAt.IsZero() && timestamp.After(br.ScheduledAt) {
+		br.scheduleDelay = timestamp.Sub(br.ScheduledAt)
+	}
+	timeForRequest, respCode, err := br.makeRequest()
+	br.timing = PartialTiming{
+		Guid:           br.Guid,
+		Timestamp:      timestamp,
+		TotalRoundtrip: timeForRequest,
+		ResponseCode:   respCode,
+		SizeClass:      br.shape.SizeClass,
+		ClientTiming:   br.clientTiming,
+	}
 	if err != nil {
-		return BenchmarkResponse{}, err
+		return &TransportError{PartialTiming: br.timing, Err: err}
 	}
+	return nil
+}
 
-	timeInApp := time.Unix(0, *br.httpStartStop.StopTimestamp).Sub(time.Unix(0, *br.httpStartStop.StartTimestamp))
-	re, _ := regexp.Compile("response_time:([^ ]+)")
-	respTimeSecs := re.FindSubmatch(br.logMessage.Message)
-	if respTimeSecs == nil {
-		err = errors.New("Error could not parse 'response_time' in log messages")
-		return BenchmarkResponse{}, err
+// Collect waits for the gorouter envelopes of a sent request and combines
+// them with its HTTP timing.
+func (br *BenchmarkRequest) Collect() (BenchmarkResponse, error) {
+	timing := br.timing
+	timeForRequest := timing.TotalRoundtrip
+
+	if !br.grabMessages() {
+		if !br.hasHttpStartStop() {
+			return BenchmarkResponse{}, &MissingHttpStartStopError{PartialTiming: timing, MissingLogMessage: !br.hasLogMessage()}
+		}
+		return BenchmarkResponse{}, &MissingLogMessageError{PartialTiming: timing, TimeInApp: br.timeInApp()}
+	}
+
+	timeInApp := br.timeInApp()
+	accessLog, err := ParseAccessLogRecord(br.logMessage.Message)
+	if err != nil || !accessLog.HasResponseTime {
+		return BenchmarkResponse{}, &ParseError{PartialTiming: timing, TimeInApp: timeInApp, LogMessage: string(br.logMessage.Message)}
 	}
-	respTime, _ := time.ParseDuration(string(respTimeSecs[1]) + "s")
-	timeInRouter := respTime - timeInApp
+	respTime := accessLog.ResponseTime
+	derivedTimeInRouter := respTime - timeInApp
 	restOfTime := timeForRequest - respTime
 
+	timeInRouter, derivedTimeInApp := derivedTimeInRouter, timeInApp
+	if accessLog.HasGorouterTime && accessLog.HasAppTime {
+		timeInRouter, timeInApp = accessLog.GorouterTime, accessLog.AppTime
+	} else if accessLog.HasGorouterTime {
+		timeInRouter, timeInApp = accessLog.GorouterTime, respTime-accessLog.GorouterTime
+	} else if accessLog.HasAppTime {
+		timeInRouter, timeInApp = respTime-accessLog.AppTime, accessLog.AppTime
+	}
+
 	response := BenchmarkResponse{
+		Guid:          br.Guid,
 		TotalRoundrip: timeForRequest,
 		TimeInApp:     timeInApp,
 		TimeInRouter:  timeInRouter,
 		RestOfTime:    restOfTime,
-		ResponseCode:  respCode,
-		Timestamp:     timestamp,
+
+		DerivedTimeInApp:    derivedTimeInApp,
+		DerivedTimeInRouter: derivedTimeInRouter,
+		RouterTiming:        accessLog.HasGorouterTime || accessLog.HasAppTime,
+
+		ResponseCode:  timing.ResponseCode,
+		Timestamp:     timing.Timestamp,
+		ScheduleDelay: br.scheduleDelay,
+		SizeClass:     br.shape.SizeClass,
+		RequestId:     br.requestId,
+		InstanceId:    br.httpStartStop.GetInstanceId(),
+		AccessLog:     accessLog,
+		Router:        br.router,
+		BodyBytes:     br.bodyBytes,
+		ClientTiming:  br.clientTiming,
+	}
+	if br.httpStartStop.InstanceIndex != nil {
+		response.InstanceIndex = strconv.Itoa(int(*br.httpStartStop.InstanceIndex))
 	}
 
 	return response, nil
 }
 
-func complete(messages []*events.Envelope) bool {
-	var http, log bool
-	for _, message := range messages {
-		if *message.EventType == events.Envelope_HttpStartStop {
-			http = true
-		} else if *message.EventType == events.Envelope_LogMessage {
-			log = true
-		}
-	}
-	return http && log
+// RequestId is the X-Vcap-Request-Id the router returned for a sent
+// benchmark, or empty if it did not return one.
+func (br *BenchmarkRequest) RequestId() string {
+	return br.requestId
+}
+
+// CompletedAt is when the HTTP request of a sent benchmark finished.
+func (br *BenchmarkRequest) CompletedAt() time.Time {
+	return br.timing.CompletedAt()
 }
 
-func (br *BenchmarkRequest) grabMessages() error {
-	messages := []*events.Envelope{}
+func (br *BenchmarkRequest) grabMessages() bool {
 	timeout := time.After(br.timeout)
 
-	for !complete(messages) {
+	for !br.hasHttpStartStop() || !br.hasLogMessage() {
 		select {
 		case message := <-br.ch:
 			if br.checkMessage(message) {
-				messages = append(messages, message)
+				br.recordMessage(message)
 			}
 		case <-timeout:
-			return errors.New("timed out getting messages for request: " + br.Guid.String())
+			return false
 		}
 	}
 
-	for _, message := range messages {
-		if !br.hasHttpStartStop() && *message.EventType == events.Envelope_HttpStartStop {
-			br.httpStartStop = *message.GetHttpStartStop()
-		} else if !br.hasLogMessage() && *message.EventType == events.Envelope_LogMessage {
-			br.logMessage = *message.GetLogMessage()
+	return true
+}
+
+func (br *BenchmarkRequest) recordMessage(message *events.Envelope) {
+	if !br.hasHttpStartStop() && *message.EventType == events.Envelope_HttpStartStop {
+		br.httpStartStop = *message.GetHttpStartStop()
+		br.router = NewRouterMetadata(message)
+	} else if !br.hasLogMessage() && *message.EventType == events.Envelope_LogMessage {
+		br.logMessage = *message.GetLogMessage()
+		if br.router == (RouterMetadata{}) {
+			br.router = NewRouterMetadata(message)
 		}
 	}
+}
 
-	return nil
+func (br *BenchmarkRequest) timeInApp() time.Duration {
+	return time.Unix(0, *br.httpStartStop.StopTimestamp).Sub(time.Unix(0, *br.httpStartStop.StartTimestamp))
 }
 
 func (br *BenchmarkRequest) hasHttpStartStop() bool {
@@ -118,7 +198,21 @@ func (br *BenchmarkRequest) hasLogMessage() bool {
 	return len(br.logMessage.Message) > 0
 }
 
+// checkMessage matches envelopes on the X-Vcap-Request-Id returned with the
+// response, falling back to the request GUID in the URI or log line.
 func (br *BenchmarkRequest) checkMessage(message *events.Envelope) bool {
+	if br.requestId != "" {
+		if *message.EventType == events.Envelope_HttpStartStop {
+			if id, ok := RequestIdFromEvent(message.HttpStartStop.RequestId); ok {
+				return id == br.requestId
+			}
+		} else if *message.EventType == events.Envelope_LogMessage {
+			if id := VcapRequestId(message.LogMessage.Message); id != "" {
+				return id == br.requestId
+			}
+		}
+	}
+
 	var toCheck string
 
 	if *message.EventType == events.Envelope_HttpStartStop {
@@ -130,8 +224,30 @@ func (br *BenchmarkRequest) checkMessage(message *events.Envelope) bool {
 	return strings.Contains(toCheck, br.Guid.String())
 }
 
-func (br *BenchmarkRequest) makeRequest() (time.Duration, int) {
+func (br *BenchmarkRequest) makeRequest() (time.Duration, int, error) {
+	req, err := br.shape.NewRequest(br.appUrl, br.Guid.String())
+	if err != nil {
+		return 0, 0, err
+	}
+
+	ctx, cancel := context.WithTimeout(req.Context(), br.HttpTimeout)
+	defer cancel()
+
+	tracer := newClientTracer(br.clock)
+	req = req.WithContext(httptrace.WithClientTrace(ctx, tracer.ClientTrace()))
+
 	start := br.clock.Now()
-	resp, _ := http.Get(br.appUrl + "/" + br.Guid.String() + ".html")
-	return br.clock.Since(start), resp.StatusCode
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		br.clientTiming = tracer.Timing()
+		return br.clock.Since(start), 0, err
+	}
+	defer resp.Body.Close()
+	br.requestId = strings.ToLower(resp.Header.Get(VCAP_REQUEST_ID_HEADER))
+
+	bodyStart := br.clock.Now()
+	br.bodyBytes, err = io.Copy(ioutil.Discard, resp.Body)
+	tracer.SetBodyRead(br.clock.Since(bodyStart))
+	br.clientTiming = tracer.Timing()
+	return br.clock.Since(start), resp.StatusCode, err
 }
@@ -2,18 +2,27 @@ package benchmark_test
 
 import (
 	"net/http"
+	"sync"
 	"time"
 
 	. "github.com/cloudfoundry-incubator/thoth/benchmark"
 	"github.com/cloudfoundry/sonde-go/events"
+	"github.com/google/uuid"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	"github.com/onsi/gomega/ghttp"
 )
 
+type fakeTimer struct {
+	deadline time.Time
+	ch       chan time.Time
+}
+
 type FakeClock struct {
+	lock        sync.Mutex
 	currentTime time.Time
+	timers      []fakeTimer
 }
 
 func NewFakeClock() *FakeClock {
@@ -23,6 +32,8 @@ func NewFakeClock() *FakeClock {
 }
 
 func (fc *FakeClock) Now() time.Time {
+	fc.lock.Lock()
+	defer fc.lock.Unlock()
 	return fc.currentTime
 }
 
@@ -30,8 +41,38 @@ func (fc *FakeClock) Since(t time.Time) time.Duration {
 	return fc.Now().Sub(t)
 }
 
+func (fc *FakeClock) After(d time.Duration) <-chan time.Time {
+	fc.lock.Lock()
+	defer fc.lock.Unlock()
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- fc.currentTime
+		return ch
+	}
+	fc.timers = append(fc.timers, fakeTimer{deadline: fc.currentTime.Add(d), ch: ch})
+	return ch
+}
+
+// Timers is the number of After channels that have not fired yet.
+func (fc *FakeClock) Timers() int {
+	fc.lock.Lock()
+	defer fc.lock.Unlock()
+	return len(fc.timers)
+}
+
 func (fc *FakeClock) Elapse(d time.Duration) {
+	fc.lock.Lock()
+	defer fc.lock.Unlock()
 	fc.currentTime = fc.currentTime.Add(d)
+	pending := fc.timers[:0]
+	for _, timer := range fc.timers {
+		if timer.deadline.After(fc.currentTime) {
+			pending = append(pending, timer)
+		} else {
+			timer.ch <- fc.currentTime
+		}
+	}
+	fc.timers = pending
 }
 
 var _ = Describe("BenchmarkRequest", func() {
@@ -48,7 +89,7 @@ var _ = Describe("BenchmarkRequest", func() {
 			clock = NewFakeClock()
 			server = ghttp.NewServer()
 			var err error
-			br, err = NewBenchmarkRequest(server.URL(), ch, clock, 100*time.Millisecond)
+			br, err = NewBenchmarkRequest(server.URL(), DefaultRequestShape, ch, clock, 100*time.Millisecond)
 			Expect(err).NotTo(HaveOccurred())
 		})
 
@@ -63,13 +104,21 @@ var _ = Describe("BenchmarkRequest", func() {
 						startTimeUnix := startTime.UnixNano()
 						stopTimeUnix := startTime.Add(20 * time.Millisecond).UnixNano()
 						uri := server.URL() + "/" + br.Guid.String() + ".html"
+						instanceIndex := int32(1)
+						instanceId := "c0ffee"
 
+						job, index, ip := "router", "2", "10.0.16.2"
 						ch <- &events.Envelope{
 							EventType: &eventType,
+							Job:       &job,
+							Index:     &index,
+							Ip:        &ip,
 							HttpStartStop: &events.HttpStartStop{
 								Uri:            &uri,
 								StartTimestamp: &startTimeUnix,
 								StopTimestamp:  &stopTimeUnix,
+								InstanceIndex:  &instanceIndex,
+								InstanceId:     &instanceId,
 							},
 						}
 
@@ -97,6 +146,43 @@ var _ = Describe("BenchmarkRequest", func() {
 				Expect(response.TotalRoundrip).To(Equal(50 * time.Millisecond))
 			})
 
+			It("corrects the roundtrip for requests that started late", func() {
+				br.ScheduledAt = clock.Now().Add(-30 * time.Millisecond)
+				response, err := br.Do()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(response.ScheduleDelay).To(Equal(30 * time.Millisecond))
+				Expect(response.CorrectedRoundtrip()).To(Equal(80 * time.Millisecond))
+			})
+
+			It("records the gorouter that emitted the envelopes", func() {
+				response, err := br.Do()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(response.Router).To(Equal(RouterMetadata{Job: "router", Index: "2", Ip: "10.0.16.2"}))
+				Expect(response.Tags()).To(ContainElement("router_job:router"))
+				Expect(response.Tags()).To(ContainElement("router_index:2"))
+			})
+
+			It("exposes the parsed access log and the body size the client read", func() {
+				response, err := br.Do()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(response.AccessLog.HasResponseTime).To(BeTrue())
+				Expect(response.AccessLog.ResponseTime).To(Equal(30 * time.Millisecond))
+				Expect(response.BodyBytes).To(BeZero())
+			})
+
+			It("records the instance that served the request", func() {
+				response, err := br.Do()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(response.InstanceIndex).To(Equal("1"))
+				Expect(response.InstanceId).To(Equal("c0ffee"))
+			})
+
+			It("knows when the HTTP request completed", func() {
+				response, err := br.Do()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(br.CompletedAt()).To(Equal(response.Timestamp.Add(response.TotalRoundrip)))
+			})
+
 			It("returns time spent in app", func() {
 				response, err := br.Do()
 				Expect(err).NotTo(HaveOccurred())
@@ -115,6 +201,14 @@ var _ = Describe("BenchmarkRequest", func() {
 				Expect(response.RestOfTime).To(Equal(20 * time.Millisecond))
 			})
 
+			It("returns the client side breakdown of the request", func() {
+				response, err := br.Do()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(response.TimeToFirstByte).To(Equal(50 * time.Millisecond))
+				Expect(response.DNSLookup).To(BeZero())
+				Expect(response.TLSHandshake).To(BeZero())
+			})
+
 			It("returns timestamp when it started", func() {
 				response, err := br.Do()
 				Expect(err).NotTo(HaveOccurred())
@@ -122,6 +216,224 @@ var _ = Describe("BenchmarkRequest", func() {
 			})
 		})
 
+		Context("sending and collecting separately", func() {
+			BeforeEach(func() {
+				server.AppendHandlers(
+					func(w http.ResponseWriter, r *http.Request) {
+						clock.Elapse(50 * time.Millisecond)
+						w.WriteHeader(http.StatusOK)
+					},
+				)
+			})
+
+			It("records the HTTP timing before the envelopes arrive", func() {
+				Expect(br.Send()).To(Succeed())
+				Expect(ch).To(BeEmpty())
+
+				eventType := events.Envelope_HttpStartStop
+				startTime := time.Time{}.UnixNano()
+				stopTime := time.Time{}.Add(20 * time.Millisecond).UnixNano()
+				uri := "/" + br.Guid.String() + ".html"
+				ch <- &events.Envelope{
+					EventType:     &eventType,
+					HttpStartStop: &events.HttpStartStop{Uri: &uri, StartTimestamp: &startTime, StopTimestamp: &stopTime},
+				}
+				eventTypeLog := events.Envelope_LogMessage
+				ch <- &events.Envelope{
+					EventType:  &eventTypeLog,
+					LogMessage: &events.LogMessage{Message: []byte("response_time:0.03 " + uri)},
+				}
+
+				clock.Elapse(time.Second)
+				response, err := br.Collect()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(response.TotalRoundrip).To(Equal(50 * time.Millisecond))
+				Expect(response.Timestamp).To(Equal(time.Unix(123456789, 0)))
+				Expect(response.TimeInRouter).To(Equal(10 * time.Millisecond))
+			})
+		})
+
+		Context("the gorouter logs its own timings", func() {
+			var logLine string
+
+			BeforeEach(func() {
+				server.AppendHandlers(
+					func(w http.ResponseWriter, r *http.Request) {
+						clock.Elapse(50 * time.Millisecond)
+						w.WriteHeader(http.StatusOK)
+
+						eventType := events.Envelope_HttpStartStop
+						startTime := time.Time{}.UnixNano()
+						stopTime := time.Time{}.Add(20 * time.Millisecond).UnixNano()
+						uri := "/" + br.Guid.String() + ".html"
+						ch <- &events.Envelope{
+							EventType: &eventType,
+							HttpStartStop: &events.HttpStartStop{
+								Uri:            &uri,
+								StartTimestamp: &startTime,
+								StopTimestamp:  &stopTime,
+							},
+						}
+
+						eventTypeLog := events.Envelope_LogMessage
+						ch <- &events.Envelope{
+							EventType:  &eventTypeLog,
+							LogMessage: &events.LogMessage{Message: []byte(logLine + " /" + br.Guid.String() + ".html")},
+						}
+					},
+				)
+			})
+
+			Context("with gorouter_time and app_time", func() {
+				BeforeEach(func() {
+					logLine = "response_time:0.03 gorouter_time:0.004 app_time:0.026"
+				})
+
+				It("reports the router's split alongside the derived one", func() {
+					response, err := br.Do()
+					Expect(err).NotTo(HaveOccurred())
+					Expect(response.RouterTiming).To(BeTrue())
+					Expect(response.TimeInRouter).To(Equal(4 * time.Millisecond))
+					Expect(response.TimeInApp).To(Equal(26 * time.Millisecond))
+					Expect(response.DerivedTimeInRouter).To(Equal(10 * time.Millisecond))
+					Expect(response.DerivedTimeInApp).To(Equal(20 * time.Millisecond))
+					Expect(response.RestOfTime).To(Equal(20 * time.Millisecond))
+				})
+			})
+
+			Context("with only gorouter_time", func() {
+				BeforeEach(func() {
+					logLine = "response_time:0.03 gorouter_time:0.004"
+				})
+
+				It("attributes the rest of the response time to the app", func() {
+					response, err := br.Do()
+					Expect(err).NotTo(HaveOccurred())
+					Expect(response.TimeInRouter).To(Equal(4 * time.Millisecond))
+					Expect(response.TimeInApp).To(Equal(26 * time.Millisecond))
+				})
+			})
+
+			Context("without router timings", func() {
+				BeforeEach(func() {
+					logLine = "response_time:0.03"
+				})
+
+				It("falls back to the derived split", func() {
+					response, err := br.Do()
+					Expect(err).NotTo(HaveOccurred())
+					Expect(response.RouterTiming).To(BeFalse())
+					Expect(response.TimeInRouter).To(Equal(10 * time.Millisecond))
+					Expect(response.TimeInApp).To(Equal(20 * time.Millisecond))
+					Expect(response.DerivedTimeInRouter).To(Equal(response.TimeInRouter))
+					Expect(response.DerivedTimeInApp).To(Equal(response.TimeInApp))
+				})
+			})
+		})
+
+		Context("the app returns the X-Vcap-Request-Id", func() {
+			var requestId uuid.UUID
+
+			BeforeEach(func() {
+				ch = make(chan *events.Envelope, 4)
+				var err error
+				br, err = NewBenchmarkRequest(server.URL(), DefaultRequestShape, ch, clock, 100*time.Millisecond)
+				Expect(err).NotTo(HaveOccurred())
+				requestId = uuid.New()
+
+				server.AppendHandlers(
+					func(w http.ResponseWriter, r *http.Request) {
+						w.Header().Set("X-Vcap-Request-Id", requestId.String())
+						w.WriteHeader(http.StatusOK)
+
+						httpStartStop := func(id uuid.UUID, uri string, stop time.Duration) *events.Envelope {
+							eventType := events.Envelope_HttpStartStop
+							start := time.Time{}.UnixNano()
+							stopped := time.Time{}.Add(stop).UnixNano()
+							return &events.Envelope{
+								EventType: &eventType,
+								HttpStartStop: &events.HttpStartStop{
+									RequestId:      EventRequestId(id),
+									Uri:            &uri,
+									StartTimestamp: &start,
+									StopTimestamp:  &stopped,
+								},
+							}
+						}
+						logMessage := func(message string) *events.Envelope {
+							eventType := events.Envelope_LogMessage
+							return &events.Envelope{EventType: &eventType, LogMessage: &events.LogMessage{Message: []byte(message)}}
+						}
+
+						ch <- httpStartStop(uuid.New(), "/"+br.Guid.String()+".html", 5*time.Millisecond)
+						ch <- logMessage(`"GET /` + br.Guid.String() + `.html" response_time:0.5 vcap_request_id:"` + uuid.New().String() + `"`)
+						ch <- httpStartStop(requestId, "/retried.html", 20*time.Millisecond)
+						ch <- logMessage(`"GET /retried.html" response_time:0.03 vcap_request_id:"` + requestId.String() + `"`)
+					},
+				)
+			})
+
+			It("matches the envelopes on the request id instead of the GUID", func() {
+				response, err := br.Do()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(response.RequestId).To(Equal(requestId.String()))
+				Expect(response.TimeInApp).To(Equal(20 * time.Millisecond))
+				Expect(response.TimeInRouter).To(Equal(10 * time.Millisecond))
+			})
+
+			It("exposes the request id once the request was sent", func() {
+				Expect(br.RequestId()).To(BeEmpty())
+				Expect(br.Send()).To(Succeed())
+				Expect(br.RequestId()).To(Equal(requestId.String()))
+			})
+		})
+
+		Context("the app is unreachable", func() {
+			BeforeEach(func() {
+				server.Close()
+			})
+
+			It("returns a transport error instead of panicking", func() {
+				_, err := br.Do()
+				Expect(err).To(BeAssignableToTypeOf(&TransportError{}))
+				Expect(ClassifyError(err)).To(Equal(OutcomeTransportError))
+
+				transportErr := err.(*TransportError)
+				Expect(transportErr.Guid).To(Equal(br.Guid))
+				Expect(transportErr.Timestamp).To(Equal(time.Unix(123456789, 0)))
+				Expect(transportErr.ResponseCode).To(BeZero())
+				Expect(transportErr.Timeout()).To(BeFalse())
+				Expect(IsTimeout(err)).To(BeFalse())
+			})
+		})
+
+		Context("the app does not answer in time", func() {
+			var unblock chan struct{}
+
+			BeforeEach(func() {
+				unblock = make(chan struct{})
+				br.HttpTimeout = 50 * time.Millisecond
+				server.AppendHandlers(
+					func(w http.ResponseWriter, r *http.Request) {
+						<-unblock
+					},
+				)
+			})
+
+			AfterEach(func() {
+				close(unblock)
+			})
+
+			It("gives up and returns a timed out transport error", func() {
+				_, err := br.Do()
+				Expect(err).To(BeAssignableToTypeOf(&TransportError{}))
+				Expect(ClassifyError(err)).To(Equal(OutcomeTransportError))
+				Expect(err.(*TransportError).Timeout()).To(BeTrue())
+				Expect(IsTimeout(err)).To(BeTrue())
+				Expect(err.Error()).To(ContainSubstring("timed out"))
+			})
+		})
+
 		Context("messages are not delivered", func() {
 			BeforeEach(func() {
 				server.AppendHandlers(
@@ -134,6 +446,16 @@ var _ = Describe("BenchmarkRequest", func() {
 			It("times out", func() {
 				_, err := br.Do()
 				Expect(err).To(HaveOccurred())
+				Expect(IsTimeout(err)).To(BeTrue())
+				Expect(FailureTags(err)).To(Equal([]string{"status:200", "outcome:envelope_timeout"}))
+			})
+
+			It("reports both envelopes as missing", func() {
+				_, err := br.Do()
+				Expect(err).To(BeAssignableToTypeOf(&MissingHttpStartStopError{}))
+				Expect(err.(*MissingHttpStartStopError).MissingLogMessage).To(BeTrue())
+				Expect(err.(*MissingHttpStartStopError).ResponseCode).To(Equal(http.StatusOK))
+				Expect(MissingEnvelopes(err)).To(ConsistOf(events.Envelope_HttpStartStop, events.Envelope_LogMessage))
 			})
 		})
 
@@ -144,7 +466,7 @@ var _ = Describe("BenchmarkRequest", func() {
 						w.WriteHeader(http.StatusOK)
 
 						eventTypeLog := events.Envelope_LogMessage
-						logMessage := "response_time:0.03 /potato"
+						logMessage := "response_time:0.03 /" + br.Guid.String() + ".html"
 						ch <- &events.Envelope{
 							EventType: &eventTypeLog,
 							LogMessage: &events.LogMessage{
@@ -159,6 +481,13 @@ var _ = Describe("BenchmarkRequest", func() {
 				_, err := br.Do()
 				Expect(err).To(HaveOccurred())
 			})
+
+			It("reports the missing HttpStartStop", func() {
+				_, err := br.Do()
+				Expect(err).To(BeAssignableToTypeOf(&MissingHttpStartStopError{}))
+				Expect(err.(*MissingHttpStartStopError).MissingLogMessage).To(BeFalse())
+				Expect(MissingEnvelopes(err)).To(Equal([]events.Envelope_EventType{events.Envelope_HttpStartStop}))
+			})
 		})
 
 		Context("LogMessage is not received", func() {
@@ -170,7 +499,7 @@ var _ = Describe("BenchmarkRequest", func() {
 						startTime := time.Time{}
 						startTimeUnix := startTime.UnixNano()
 						stopTimeUnix := startTime.Add(20 * time.Millisecond).UnixNano()
-						uri := "notyourapp"
+						uri := server.URL() + "/" + br.Guid.String() + ".html"
 
 						ch <- &events.Envelope{
 							EventType: &eventType,
@@ -188,6 +517,58 @@ var _ = Describe("BenchmarkRequest", func() {
 				_, err := br.Do()
 				Expect(err).To(HaveOccurred())
 			})
+
+			It("reports the missing LogMessage with the time spent in app", func() {
+				_, err := br.Do()
+				Expect(err).To(BeAssignableToTypeOf(&MissingLogMessageError{}))
+				Expect(err.(*MissingLogMessageError).TimeInApp).To(Equal(20 * time.Millisecond))
+				Expect(err.(*MissingLogMessageError).Guid).To(Equal(br.Guid))
+			})
+		})
+
+		Context("response_time is missing from the log message", func() {
+			BeforeEach(func() {
+				server.AppendHandlers(
+					func(w http.ResponseWriter, r *http.Request) {
+						clock.Elapse(50 * time.Millisecond)
+						w.WriteHeader(http.StatusOK)
+						eventType := events.Envelope_HttpStartStop
+						startTime := time.Time{}
+						startTimeUnix := startTime.UnixNano()
+						stopTimeUnix := startTime.Add(20 * time.Millisecond).UnixNano()
+						uri := server.URL() + "/" + br.Guid.String() + ".html"
+
+						ch <- &events.Envelope{
+							EventType: &eventType,
+							HttpStartStop: &events.HttpStartStop{
+								Uri:            &uri,
+								StartTimestamp: &startTimeUnix,
+								StopTimestamp:  &stopTimeUnix,
+							},
+						}
+
+						eventTypeLog := events.Envelope_LogMessage
+						logMessage := "GET /" + br.Guid.String() + ".html"
+						ch <- &events.Envelope{
+							EventType: &eventTypeLog,
+							LogMessage: &events.LogMessage{
+								Message: []byte(logMessage),
+							},
+						}
+					},
+				)
+			})
+
+			It("returns a parse error with the partial timings", func() {
+				_, err := br.Do()
+				Expect(err).To(BeAssignableToTypeOf(&ParseError{}))
+				Expect(ClassifyError(err)).To(Equal(OutcomeParseError))
+
+				parseErr := err.(*ParseError)
+				Expect(parseErr.TotalRoundtrip).To(Equal(50 * time.Millisecond))
+				Expect(parseErr.TimeInApp).To(Equal(20 * time.Millisecond))
+				Expect(parseErr.LogMessage).To(Equal("GET /" + br.Guid.String() + ".html"))
+			})
 		})
 
 		Context("receiving non-matching HttpStartStop", func() {
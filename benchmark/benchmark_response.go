@@ -2,56 +2,129 @@ package benchmark
 
 import (
 	"strconv"
+	"strings"
 	"time"
+
+	"github.com/google/uuid"
 )
 
+const INFLUX_MEASUREMENT = "app_benchmarking"
+
+var influxTagEscaper = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+
 type BenchmarkResponse struct {
+	Guid          uuid.UUID
+	RequestId     string
 	TotalRoundrip time.Duration
 	TimeInApp     time.Duration
 	TimeInRouter  time.Duration
 	RestOfTime    time.Duration
 	Timestamp     time.Time
+	ScheduleDelay time.Duration
+
+	// TimeInApp and TimeInRouter are the gorouter's own gorouter_time and
+	// app_time when it logs them. The Derived values always come from the
+	// HttpStartStop timestamps, which mix the router and app host clocks.
+	DerivedTimeInApp    time.Duration
+	DerivedTimeInRouter time.Duration
+	RouterTiming        bool
+
+	ResponseCode  int
+	SizeClass     string
+	InstanceIndex string
+	InstanceId    string
+	BodyBytes     int64
+	AccessLog     AccessLogRecord
+	Router        RouterMetadata
+
+	ClientTiming
+}
+
+type Phase struct {
+	Name     string
+	Duration time.Duration
+}
+
+// PhaseNames lists every phase a response may have, including the derived
+// ones only reported when the router logged its own timing.
+var PhaseNames = phaseNames(BenchmarkResponse{RouterTiming: true}.Phases())
+
+func (br BenchmarkResponse) Phases() []Phase {
+	phases := []Phase{
+		{"total_roundtrip", br.TotalRoundrip},
+		{"corrected_roundtrip", br.CorrectedRoundtrip()},
+		{"time_in_gorouter", br.TimeInRouter},
+		{"time_in_app", br.TimeInApp},
+		{"rest_of_time", br.RestOfTime},
+	}
+	if br.RouterTiming {
+		phases = append(phases,
+			Phase{"derived_time_in_gorouter", br.DerivedTimeInRouter},
+			Phase{"derived_time_in_app", br.DerivedTimeInApp},
+		)
+	}
+	return append(phases,
+		Phase{"dns_lookup", br.DNSLookup},
+		Phase{"connect", br.Connect},
+		Phase{"tls_handshake", br.TLSHandshake},
+		Phase{"time_to_first_byte", br.TimeToFirstByte},
+		Phase{"body_read", br.BodyRead},
+	)
+}
 
-	ResponseCode int
+// CorrectedRoundtrip is the roundtrip measured from when the request was
+// scheduled to start, correcting open-loop runs for coordinated omission.
+func (br BenchmarkResponse) CorrectedRoundtrip() time.Duration {
+	return br.TotalRoundrip + br.ScheduleDelay
 }
 
-func (br BenchmarkResponse) ToDatadog(deploymentName string, index int) map[string]interface{} {
-	now := br.Timestamp
+func phaseNames(phases []Phase) []string {
+	names := []string{}
+	for _, phase := range phases {
+		names = append(names, phase.Name)
+	}
+	return names
+}
+
+func (br BenchmarkResponse) Outcome() Outcome {
+	if br.ResponseCode/100 != 2 {
+		return OutcomeHttpError
+	}
+	return OutcomeSuccess
+}
+
+func (br BenchmarkResponse) Tags() []string {
 	tags := []string{
 		"status:" + strconv.Itoa(br.ResponseCode),
-		"deployment:" + deploymentName,
-		"index:" + strconv.Itoa(index),
-	}
-	return map[string]interface{}{
-		"series": []map[string]interface{}{
-			{
-				"metric": "app_benchmarking.total_roundtrip",
-				"points": [][]int64{
-					{now.Unix(), br.TotalRoundrip.Nanoseconds()},
-				},
-				"tags": tags,
-			},
-			{
-				"metric": "app_benchmarking.time_in_gorouter",
-				"points": [][]int64{
-					{now.Unix(), br.TimeInRouter.Nanoseconds()},
-				},
-				"tags": tags,
-			},
-			{
-				"metric": "app_benchmarking.time_in_app",
-				"points": [][]int64{
-					{now.Unix(), br.TimeInApp.Nanoseconds()},
-				},
-				"tags": tags,
-			},
-			{
-				"metric": "app_benchmarking.rest_of_time",
-				"points": [][]int64{
-					{now.Unix(), br.RestOfTime.Nanoseconds()},
-				},
-				"tags": tags,
-			},
-		},
+		"outcome:" + string(br.Outcome()),
+	}
+	if br.SizeClass != "" {
+		tags = append(tags, "size:"+br.SizeClass)
+	}
+	tags = append(tags, br.Router.Tags()...)
+	if br.InstanceIndex != "" {
+		tags = append(tags, "instance_index:"+br.InstanceIndex)
+	}
+	return tags
+}
+
+func (br BenchmarkResponse) ToInflux(extraTags []string) string {
+	fields := []string{}
+	for _, phase := range br.Phases() {
+		fields = append(fields, phase.Name+"="+strconv.FormatInt(phase.Duration.Nanoseconds(), 10)+"i")
+	}
+	fields = append(fields, "response_code="+strconv.Itoa(br.ResponseCode)+"i")
+	return InfluxLine(INFLUX_MEASUREMENT, append(br.Tags(), extraTags...), fields, br.Timestamp)
+}
+
+func InfluxLine(measurement string, tags []string, fields []string, timestamp time.Time) string {
+	line := influxTagEscaper.Replace(measurement)
+	for _, tag := range tags {
+		parts := strings.SplitN(tag, ":", 2)
+		if len(parts) != 2 || parts[1] == "" {
+			continue
+		}
+		line += "," + influxTagEscaper.Replace(parts[0]) + "=" + influxTagEscaper.Replace(parts[1])
 	}
+	return line + " " + strings.Join(fields, ",") + " " + strconv.FormatInt(timestamp.UnixNano(), 10)
 }
@@ -0,0 +1,84 @@
+package benchmark_test
+
+import (
+	"net/http"
+	"time"
+
+	. "github.com/cloudfoundry-incubator/thoth/benchmark"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("BenchmarkResponse", func() {
+	var response BenchmarkResponse
+
+	BeforeEach(func() {
+		response = BenchmarkResponse{
+			TotalRoundrip: 50 * time.Millisecond,
+			TimeInApp:     20 * time.Millisecond,
+			TimeInRouter:  10 * time.Millisecond,
+			RestOfTime:    20 * time.Millisecond,
+			Timestamp:     time.Unix(123456789, 5),
+			ResponseCode:  http.StatusOK,
+		}
+	})
+
+	Describe("Tags()", func() {
+		It("includes the status and outcome", func() {
+			Expect(response.Tags()).To(Equal([]string{"status:200", "outcome:success"}))
+		})
+
+		It("treats non-2xx responses as http errors", func() {
+			response.ResponseCode = http.StatusBadGateway
+			Expect(response.Tags()).To(Equal([]string{"status:502", "outcome:http_error"}))
+		})
+
+		It("includes the gorouter job and index", func() {
+			response.Router = RouterMetadata{Deployment: "cf", Job: "router", Index: "0", Ip: "10.0.16.2"}
+			Expect(response.Tags()).To(Equal([]string{"status:200", "outcome:success", "router_job:router", "router_index:0"}))
+		})
+
+		It("includes the instance that served the request", func() {
+			response.InstanceIndex = "1"
+			response.InstanceId = "c0ffee"
+			Expect(response.Tags()).To(Equal([]string{"status:200", "outcome:success", "instance_index:1"}))
+		})
+
+		It("includes the size class when sweeping response sizes", func() {
+			response.SizeClass = "10KB"
+			Expect(response.Tags()).To(Equal([]string{"status:200", "outcome:success", "size:10KB"}))
+		})
+	})
+
+	Describe("Phases()", func() {
+		It("only reports the derived phases when the router logged its own timing", func() {
+			Expect(PhaseNames).To(ContainElement("derived_time_in_app"))
+			for _, phase := range response.Phases() {
+				Expect(phase.Name).NotTo(HavePrefix("derived_"))
+			}
+
+			response.RouterTiming = true
+			response.DerivedTimeInApp = 15 * time.Millisecond
+			Expect(response.Phases()).To(ContainElement(Phase{Name: "derived_time_in_app", Duration: 15 * time.Millisecond}))
+			Expect(response.Phases()).To(HaveLen(len(PhaseNames)))
+		})
+	})
+
+	Describe("ToInflux()", func() {
+		It("renders the phases as fields with a nanosecond timestamp", func() {
+			Expect(response.ToInflux([]string{"deployment:cf", "index:0"})).To(Equal(
+				"app_benchmarking,status=200,outcome=success,deployment=cf,index=0 " +
+					"total_roundtrip=50000000i,corrected_roundtrip=50000000i,time_in_gorouter=10000000i,time_in_app=20000000i,rest_of_time=20000000i," +
+					"dns_lookup=0i,connect=0i,tls_handshake=0i,time_to_first_byte=0i,body_read=0i,response_code=200i " +
+					"123456789000000005",
+			))
+		})
+
+		It("escapes tag values", func() {
+			Expect(response.ToInflux([]string{"deployment:my cf,prod"})).To(HavePrefix(
+				`app_benchmarking,status=200,outcome=success,deployment=my\ cf\,prod `,
+			))
+		})
+	})
+})
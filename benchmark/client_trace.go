@@ -0,0 +1,89 @@
+package benchmark
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+var httpClient = &http.Client{
+	Transport: &http.Transport{
+		Proxy:             http.ProxyFromEnvironment,
+		DisableKeepAlives: true,
+	},
+}
+
+// ClientTiming breaks down the client side of a request. Keep-alives are
+// disabled so every request pays for its own DNS lookup and connection.
+type ClientTiming struct {
+	DNSLookup       time.Duration
+	Connect         time.Duration
+	TLSHandshake    time.Duration
+	TimeToFirstByte time.Duration
+	BodyRead        time.Duration
+}
+
+type clientTracer struct {
+	mutex sync.Mutex
+	clock Clock
+	start time.Time
+
+	dnsStart, connectStart, tlsStart time.Time
+	timing                           ClientTiming
+}
+
+func newClientTracer(clock Clock) *clientTracer {
+	return &clientTracer{clock: clock, start: clock.Now()}
+}
+
+func (ct *clientTracer) ClientTrace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) {
+			ct.mark(&ct.dnsStart)
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			ct.measure(&ct.timing.DNSLookup, &ct.dnsStart)
+		},
+		ConnectStart: func(network, addr string) {
+			ct.mark(&ct.connectStart)
+		},
+		ConnectDone: func(network, addr string, err error) {
+			ct.measure(&ct.timing.Connect, &ct.connectStart)
+		},
+		TLSHandshakeStart: func() {
+			ct.mark(&ct.tlsStart)
+		},
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			ct.measure(&ct.timing.TLSHandshake, &ct.tlsStart)
+		},
+		GotFirstResponseByte: func() {
+			ct.measure(&ct.timing.TimeToFirstByte, &ct.start)
+		},
+	}
+}
+
+func (ct *clientTracer) Timing() ClientTiming {
+	ct.mutex.Lock()
+	defer ct.mutex.Unlock()
+	return ct.timing
+}
+
+func (ct *clientTracer) SetBodyRead(d time.Duration) {
+	ct.mutex.Lock()
+	defer ct.mutex.Unlock()
+	ct.timing.BodyRead = d
+}
+
+func (ct *clientTracer) mark(t *time.Time) {
+	ct.mutex.Lock()
+	defer ct.mutex.Unlock()
+	*t = ct.clock.Now()
+}
+
+func (ct *clientTracer) measure(d *time.Duration, since *time.Time) {
+	ct.mutex.Lock()
+	defer ct.mutex.Unlock()
+	*d = ct.clock.Since(*since)
+}
@@ -0,0 +1,39 @@
+package benchmark
+
+import (
+	"encoding/binary"
+	"regexp"
+
+	"github.com/cloudfoundry/sonde-go/events"
+	"github.com/google/uuid"
+)
+
+const VCAP_REQUEST_ID_HEADER = "X-Vcap-Request-Id"
+
+var vcapRequestIdPattern = regexp.MustCompile(`vcap_request_id:"?([0-9a-fA-F-]+)"?`)
+
+// RequestIdFromEvent converts the dropsonde UUID, stored as two little-endian
+// halves, to its string form.
+func RequestIdFromEvent(id *events.UUID) (string, bool) {
+	if id == nil || id.Low == nil || id.High == nil {
+		return "", false
+	}
+	var u uuid.UUID
+	binary.LittleEndian.PutUint64(u[:8], id.GetLow())
+	binary.LittleEndian.PutUint64(u[8:], id.GetHigh())
+	return u.String(), true
+}
+
+func EventRequestId(id uuid.UUID) *events.UUID {
+	low := binary.LittleEndian.Uint64(id[:8])
+	high := binary.LittleEndian.Uint64(id[8:])
+	return &events.UUID{Low: &low, High: &high}
+}
+
+func VcapRequestId(logMessage []byte) string {
+	match := vcapRequestIdPattern.FindSubmatch(logMessage)
+	if match == nil {
+		return ""
+	}
+	return string(match[1])
+}
@@ -0,0 +1,28 @@
+package benchmark_test
+
+import (
+	. "github.com/cloudfoundry-incubator/thoth/benchmark"
+	"github.com/google/uuid"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Correlation", func() {
+	It("round trips dropsonde request ids", func() {
+		id, err := uuid.Parse("0a1b2c3d-4e5f-6071-8293-a4b5c6d7e8f9")
+		Expect(err).NotTo(HaveOccurred())
+		requestId, ok := RequestIdFromEvent(EventRequestId(id))
+		Expect(ok).To(BeTrue())
+		Expect(requestId).To(Equal("0a1b2c3d-4e5f-6071-8293-a4b5c6d7e8f9"))
+
+		_, ok = RequestIdFromEvent(nil)
+		Expect(ok).To(BeFalse())
+	})
+
+	It("reads the vcap_request_id from quoted and unquoted gorouter log lines", func() {
+		Expect(VcapRequestId([]byte(`app.example.com - [19/04/2016:10:00:00 +0000] "GET / HTTP/1.1" 200 vcap_request_id:0a1b2c3d-4e5f-6071-8293-a4b5c6d7e8f9 response_time:0.003`))).To(Equal("0a1b2c3d-4e5f-6071-8293-a4b5c6d7e8f9"))
+		Expect(VcapRequestId([]byte(`vcap_request_id:"0a1b2c3d-4e5f-6071-8293-a4b5c6d7e8f9" response_time:0.003`))).To(Equal("0a1b2c3d-4e5f-6071-8293-a4b5c6d7e8f9"))
+		Expect(VcapRequestId([]byte(`response_time:0.003`))).To(BeEmpty())
+	})
+})
@@ -0,0 +1,61 @@
+package benchmark
+
+import (
+	"time"
+
+	"github.com/cloudfoundry/sonde-go/events"
+)
+
+type DeliveryStatus string
+
+const (
+	DeliveryOnTime DeliveryStatus = "on_time"
+	DeliveryLate   DeliveryStatus = "late"
+	DeliveryLost   DeliveryStatus = "lost"
+)
+
+// EnvelopeDelivery is the fate of an envelope a request waited for. Timestamp
+// is when thoth received it (or gave up on it, for lost envelopes), Origin is
+// the envelope's own timestamp and Gap is measured from the end of the HTTP
+// request.
+type EnvelopeDelivery struct {
+	Guid      string
+	EventType events.Envelope_EventType
+	Status    DeliveryStatus
+	Timestamp time.Time
+	Origin    time.Time
+	Gap       time.Duration
+}
+
+type DeliveryReporter func(delivery EnvelopeDelivery, tags []string)
+
+func (d EnvelopeDelivery) Tags() []string {
+	return []string{
+		"delivery:" + string(d.Status),
+		"event_type:" + EventTypeName(d.EventType),
+	}
+}
+
+// Latencies is how long the logging pipeline took to deliver the envelope,
+// since it was emitted (origin) and since the HTTP response (response).
+func (d EnvelopeDelivery) Latencies() []Phase {
+	if d.Status == DeliveryLost {
+		return nil
+	}
+	latencies := []Phase{}
+	if !d.Origin.IsZero() {
+		latencies = append(latencies, Phase{Name: "origin", Duration: d.Timestamp.Sub(d.Origin)})
+	}
+	return append(latencies, Phase{Name: "response", Duration: d.Gap})
+}
+
+func EventTypeName(eventType events.Envelope_EventType) string {
+	switch eventType {
+	case events.Envelope_HttpStartStop:
+		return "http_start_stop"
+	case events.Envelope_LogMessage:
+		return "log_message"
+	default:
+		return "other"
+	}
+}
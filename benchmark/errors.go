@@ -0,0 +1,166 @@
+package benchmark
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/cloudfoundry/sonde-go/events"
+	"github.com/google/uuid"
+)
+
+type Outcome string
+
+const (
+	OutcomeSuccess         Outcome = "success"
+	OutcomeHttpError       Outcome = "http_error"
+	OutcomeTransportError  Outcome = "transport_error"
+	OutcomeEnvelopeTimeout Outcome = "envelope_timeout"
+	OutcomeParseError      Outcome = "parse_error"
+	OutcomeError           Outcome = "error"
+)
+
+type RequestError interface {
+	error
+	RequestGuid() uuid.UUID
+	Timing() PartialTiming
+}
+
+// PartialTiming is what was measured before a benchmark failed. TotalRoundtrip
+// and ResponseCode are zero when the HTTP request itself did not complete, but
+// the ClientTiming phases reached before the failure are kept.
+type PartialTiming struct {
+	Guid           uuid.UUID
+	Timestamp      time.Time
+	TotalRoundtrip time.Duration
+	ResponseCode   int
+	SizeClass      string
+	ClientTiming
+}
+
+func (pt PartialTiming) RequestGuid() uuid.UUID {
+	return pt.Guid
+}
+
+func (pt PartialTiming) Timing() PartialTiming {
+	return pt
+}
+
+func (pt PartialTiming) CompletedAt() time.Time {
+	return pt.Timestamp.Add(pt.TotalRoundtrip)
+}
+
+type TransportError struct {
+	PartialTiming
+	Err error
+}
+
+func (e *TransportError) Error() string {
+	if e.Timeout() {
+		return "request to app timed out: " + e.Err.Error()
+	}
+	return "request to app failed: " + e.Err.Error()
+}
+
+// Timeout reports whether the request was abandoned because the app did not
+// answer within the HTTP timeout.
+func (e *TransportError) Timeout() bool {
+	if errors.Is(e.Err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(e.Err, &netErr) && netErr.Timeout()
+}
+
+type MissingHttpStartStopError struct {
+	PartialTiming
+	MissingLogMessage bool
+}
+
+func (e *MissingHttpStartStopError) Error() string {
+	if e.MissingLogMessage {
+		return "timed out getting HttpStartStop and LogMessage for request: " + e.Guid.String()
+	}
+	return "timed out getting HttpStartStop for request: " + e.Guid.String()
+}
+
+type MissingLogMessageError struct {
+	PartialTiming
+	TimeInApp time.Duration
+}
+
+func (e *MissingLogMessageError) Error() string {
+	return "timed out getting LogMessage for request: " + e.Guid.String()
+}
+
+type ParseError struct {
+	PartialTiming
+	TimeInApp  time.Duration
+	LogMessage string
+}
+
+func (e *ParseError) Error() string {
+	return "Error could not parse 'response_time' in log message: " + e.LogMessage
+}
+
+// IsTimeout reports whether the benchmark gave up waiting, either for the app
+// to answer or for its envelopes.
+func IsTimeout(err error) bool {
+	switch e := err.(type) {
+	case *MissingHttpStartStopError, *MissingLogMessageError:
+		return true
+	case *TransportError:
+		return e.Timeout()
+	default:
+		return false
+	}
+}
+
+// MissingEnvelopes lists the envelope types a timed-out request never got.
+func MissingEnvelopes(err error) []events.Envelope_EventType {
+	switch e := err.(type) {
+	case *MissingHttpStartStopError:
+		if e.MissingLogMessage {
+			return []events.Envelope_EventType{events.Envelope_HttpStartStop, events.Envelope_LogMessage}
+		}
+		return []events.Envelope_EventType{events.Envelope_HttpStartStop}
+	case *MissingLogMessageError:
+		return []events.Envelope_EventType{events.Envelope_LogMessage}
+	default:
+		return nil
+	}
+}
+
+func ClassifyError(err error) Outcome {
+	switch err.(type) {
+	case *TransportError:
+		return OutcomeTransportError
+	case *MissingHttpStartStopError, *MissingLogMessageError:
+		return OutcomeEnvelopeTimeout
+	case *ParseError:
+		return OutcomeParseError
+	default:
+		return OutcomeError
+	}
+}
+
+func FailureTags(err error) []string {
+	tags := []string{}
+	if requestErr, ok := err.(RequestError); ok && requestErr.Timing().ResponseCode != 0 {
+		tags = append(tags, "status:"+strconv.Itoa(requestErr.Timing().ResponseCode))
+	}
+	tags = append(tags, "outcome:"+string(ClassifyError(err)))
+	if requestErr, ok := err.(RequestError); ok && requestErr.Timing().SizeClass != "" {
+		tags = append(tags, "size:"+requestErr.Timing().SizeClass)
+	}
+	return tags
+}
+
+func FailureTime(err error) time.Time {
+	if requestErr, ok := err.(RequestError); ok && !requestErr.Timing().Timestamp.IsZero() {
+		return requestErr.Timing().Timestamp
+	}
+	return time.Now()
+}
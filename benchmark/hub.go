@@ -0,0 +1,369 @@
+package benchmark
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudfoundry/sonde-go/events"
+)
+
+const HUB_BUFFER_SIZE = 8
+
+var uuidPattern = regexp.MustCompile(`[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}`)
+
+type HubStats struct {
+	Matched   uint64
+	Unmatched uint64
+	Dropped   uint64
+	Late      uint64
+	Lost      uint64
+}
+
+type pendingRequest struct {
+	guid        string
+	ids         []string
+	completedAt time.Time
+	expiresAt   time.Time
+	missing     []events.Envelope_EventType
+	tags        []string
+}
+
+// Hub demultiplexes a single firehose stream between in-flight requests.
+// Requests subscribe for a channel and route the IDs they expect to it; an
+// envelope is delivered to the first subscriber registered under one of the
+// UUIDs it carries (the HttpStartStop RequestId, or any UUID in its URI or
+// log line, which covers both the URL GUID and vcap_request_id).
+//
+// The first envelope of each type is timestamped on arrival and reported as
+// on time once the request completes. Requests that time out can be abandoned
+// to a bounded pending table, so that envelopes arriving within lateWindow are
+// reported as late rather than lost.
+type Hub struct {
+	routes      map[string]chan *events.Envelope
+	subscribers map[chan *events.Envelope][]string
+	arrivals    map[chan *events.Envelope][]EnvelopeDelivery
+	pending     map[string]*pendingRequest
+	queue       []*pendingRequest
+	stats       HubStats
+	lock        sync.Mutex
+
+	clock      Clock
+	maxPending int
+	lateWindow time.Duration
+	report     DeliveryReporter
+}
+
+func NewHub(clock Clock, maxPending int, lateWindow time.Duration, report DeliveryReporter) *Hub {
+	return &Hub{
+		routes:      map[string]chan *events.Envelope{},
+		subscribers: map[chan *events.Envelope][]string{},
+		arrivals:    map[chan *events.Envelope][]EnvelopeDelivery{},
+		pending:     map[string]*pendingRequest{},
+		clock:       clock,
+		maxPending:  maxPending,
+		lateWindow:  lateWindow,
+		report:      report,
+	}
+}
+
+func (h *Hub) Subscribe() chan *events.Envelope {
+	ch := make(chan *events.Envelope, HUB_BUFFER_SIZE)
+	h.lock.Lock()
+	h.subscribers[ch] = []string{}
+	h.lock.Unlock()
+	return ch
+}
+
+func (h *Hub) Route(ch chan *events.Envelope, id string) {
+	id = strings.ToLower(id)
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if _, ok := h.subscribers[ch]; !ok {
+		return
+	}
+	h.routes[id] = ch
+	h.subscribers[ch] = append(h.subscribers[ch], id)
+}
+
+func (h *Hub) Unsubscribe(ch chan *events.Envelope) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.unsubscribe(ch)
+}
+
+func (h *Hub) unsubscribe(ch chan *events.Envelope) {
+	for _, id := range h.subscribers[ch] {
+		if h.routes[id] == ch {
+			delete(h.routes, id)
+		}
+	}
+	delete(h.subscribers, ch)
+	delete(h.arrivals, ch)
+}
+
+// Complete unsubscribes a request that got its envelopes and reports when
+// they arrived relative to completedAt, the end of its HTTP request.
+func (h *Hub) Complete(ch chan *events.Envelope, guid string, completedAt time.Time, tags []string) {
+	h.lock.Lock()
+	deliveries := h.onTime(ch, guid, completedAt, nil)
+	h.unsubscribe(ch)
+	h.lock.Unlock()
+
+	h.deliver(deliveries, tags)
+}
+
+// Abandon unsubscribes a timed-out request, keeping its IDs in the pending
+// table until the missing envelopes turn up or lateWindow passes. Envelopes
+// already buffered on the channel count as late.
+func (h *Hub) Abandon(ch chan *events.Envelope, guid string, completedAt time.Time, missing []events.Envelope_EventType, tags []string) {
+	now := h.clock.Now()
+
+	h.lock.Lock()
+	deliveries := h.onTime(ch, guid, completedAt, missing)
+	ids := h.subscribers[ch]
+	h.unsubscribe(ch)
+
+	request := &pendingRequest{
+		guid:        guid,
+		ids:         ids,
+		completedAt: completedAt,
+		expiresAt:   now.Add(h.lateWindow),
+		missing:     missing,
+		tags:        tags,
+	}
+	for drained := false; !drained; {
+		select {
+		case envelope := <-ch:
+			if delivery, ok := h.arriveLate(request, envelope, now); ok {
+				deliveries = append(deliveries, delivery)
+			}
+		default:
+			drained = true
+		}
+	}
+
+	if len(request.missing) > 0 {
+		if h.maxPending <= 0 {
+			deliveries = append(deliveries, h.lose(request, now)...)
+		} else {
+			if len(h.queue) >= h.maxPending {
+				oldest := h.queue[0]
+				h.queue = h.queue[1:]
+				h.forget(oldest)
+				deliveries = append(deliveries, h.lose(oldest, now)...)
+			}
+			h.queue = append(h.queue, request)
+			for _, id := range ids {
+				h.pending[id] = request
+			}
+		}
+	}
+	h.lock.Unlock()
+
+	h.deliver(deliveries, request.tags)
+}
+
+// Expire reports the envelopes of pending requests older than lateWindow as
+// lost.
+func (h *Hub) Expire() {
+	now := h.clock.Now()
+	expired := []*pendingRequest{}
+
+	h.lock.Lock()
+	remaining := []*pendingRequest{}
+	for _, request := range h.queue {
+		if now.Before(request.expiresAt) {
+			remaining = append(remaining, request)
+			continue
+		}
+		h.forget(request)
+		expired = append(expired, request)
+	}
+	h.queue = remaining
+
+	lost := [][]EnvelopeDelivery{}
+	for _, request := range expired {
+		lost = append(lost, h.lose(request, now))
+	}
+	h.lock.Unlock()
+
+	for i, request := range expired {
+		h.deliver(lost[i], request.tags)
+	}
+}
+
+func (h *Hub) Dispatch(envelope *events.Envelope) bool {
+	ids := envelopeIds(envelope)
+
+	h.lock.Lock()
+
+	for _, id := range ids {
+		ch, ok := h.routes[id]
+		if !ok {
+			continue
+		}
+		select {
+		case ch <- envelope:
+			h.stats.Matched++
+			h.arrive(ch, envelope, h.clock.Now())
+		default:
+			h.stats.Dropped++
+		}
+		h.lock.Unlock()
+		return true
+	}
+
+	for _, id := range ids {
+		request, ok := h.pending[id]
+		if !ok {
+			continue
+		}
+		delivery, ok := h.arriveLate(request, envelope, h.clock.Now())
+		if len(request.missing) == 0 {
+			h.forget(request)
+			h.dequeue(request)
+		}
+		h.lock.Unlock()
+
+		if ok {
+			h.deliver([]EnvelopeDelivery{delivery}, request.tags)
+		}
+		return true
+	}
+
+	h.stats.Unmatched++
+	h.lock.Unlock()
+	return false
+}
+
+func (h *Hub) Stats() HubStats {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return h.stats
+}
+
+func (h *Hub) arrive(ch chan *events.Envelope, envelope *events.Envelope, now time.Time) {
+	for _, arrival := range h.arrivals[ch] {
+		if arrival.EventType == envelope.GetEventType() {
+			return
+		}
+	}
+	h.arrivals[ch] = append(h.arrivals[ch], EnvelopeDelivery{
+		EventType: envelope.GetEventType(),
+		Status:    DeliveryOnTime,
+		Timestamp: now,
+		Origin:    origin(envelope),
+	})
+}
+
+func (h *Hub) onTime(ch chan *events.Envelope, guid string, completedAt time.Time, missing []events.Envelope_EventType) []EnvelopeDelivery {
+	deliveries := []EnvelopeDelivery{}
+	for _, arrival := range h.arrivals[ch] {
+		if containsEventType(missing, arrival.EventType) {
+			continue
+		}
+		arrival.Guid = guid
+		arrival.Gap = arrival.Timestamp.Sub(completedAt)
+		deliveries = append(deliveries, arrival)
+	}
+	return deliveries
+}
+
+func (h *Hub) arriveLate(request *pendingRequest, envelope *events.Envelope, now time.Time) (EnvelopeDelivery, bool) {
+	for i, eventType := range request.missing {
+		if eventType != envelope.GetEventType() {
+			continue
+		}
+		request.missing = append(request.missing[:i:i], request.missing[i+1:]...)
+		h.stats.Late++
+		return EnvelopeDelivery{
+			Guid:      request.guid,
+			EventType: eventType,
+			Status:    DeliveryLate,
+			Timestamp: now,
+			Origin:    origin(envelope),
+			Gap:       now.Sub(request.completedAt),
+		}, true
+	}
+	return EnvelopeDelivery{}, false
+}
+
+func (h *Hub) lose(request *pendingRequest, now time.Time) []EnvelopeDelivery {
+	deliveries := []EnvelopeDelivery{}
+	for _, eventType := range request.missing {
+		h.stats.Lost++
+		deliveries = append(deliveries, EnvelopeDelivery{
+			Guid:      request.guid,
+			EventType: eventType,
+			Status:    DeliveryLost,
+			Timestamp: now,
+			Gap:       now.Sub(request.completedAt),
+		})
+	}
+	request.missing = nil
+	return deliveries
+}
+
+func (h *Hub) forget(request *pendingRequest) {
+	for _, id := range request.ids {
+		if h.pending[id] == request {
+			delete(h.pending, id)
+		}
+	}
+}
+
+func (h *Hub) dequeue(request *pendingRequest) {
+	for i, queued := range h.queue {
+		if queued == request {
+			h.queue = append(h.queue[:i:i], h.queue[i+1:]...)
+			return
+		}
+	}
+}
+
+func (h *Hub) deliver(deliveries []EnvelopeDelivery, tags []string) {
+	if h.report == nil {
+		return
+	}
+	for _, delivery := range deliveries {
+		h.report(delivery, tags)
+	}
+}
+
+func origin(envelope *events.Envelope) time.Time {
+	if envelope.Timestamp == nil {
+		return time.Time{}
+	}
+	return time.Unix(0, envelope.GetTimestamp())
+}
+
+func containsEventType(eventTypes []events.Envelope_EventType, eventType events.Envelope_EventType) bool {
+	for _, t := range eventTypes {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+func envelopeIds(envelope *events.Envelope) []string {
+	var text string
+	ids := []string{}
+	switch envelope.GetEventType() {
+	case events.Envelope_HttpStartStop:
+		if id, ok := RequestIdFromEvent(envelope.GetHttpStartStop().GetRequestId()); ok {
+			ids = append(ids, id)
+		}
+		text = envelope.GetHttpStartStop().GetUri()
+	case events.Envelope_LogMessage:
+		text = string(envelope.GetLogMessage().GetMessage())
+	}
+
+	for _, id := range uuidPattern.FindAllString(text, -1) {
+		ids = append(ids, strings.ToLower(id))
+	}
+	return ids
+}
@@ -0,0 +1,221 @@
+package benchmark_test
+
+import (
+	"time"
+
+	. "github.com/cloudfoundry-incubator/thoth/benchmark"
+	"github.com/cloudfoundry/sonde-go/events"
+	"github.com/google/uuid"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Hub", func() {
+	var (
+		hub        *Hub
+		clock      *FakeClock
+		guid       string
+		other      string
+		deliveries []EnvelopeDelivery
+		reported   [][]string
+	)
+
+	httpStartStop := func(uri string, requestId *events.UUID) *events.Envelope {
+		eventType := events.Envelope_HttpStartStop
+		return &events.Envelope{EventType: &eventType, HttpStartStop: &events.HttpStartStop{Uri: &uri, RequestId: requestId}}
+	}
+
+	logMessage := func(message string) *events.Envelope {
+		eventType := events.Envelope_LogMessage
+		return &events.Envelope{EventType: &eventType, LogMessage: &events.LogMessage{Message: []byte(message)}}
+	}
+
+	BeforeEach(func() {
+		clock = NewFakeClock()
+		deliveries = []EnvelopeDelivery{}
+		reported = [][]string{}
+		hub = NewHub(clock, 2, 10*time.Second, func(delivery EnvelopeDelivery, tags []string) {
+			deliveries = append(deliveries, delivery)
+			reported = append(reported, tags)
+		})
+		guid = uuid.New().String()
+		other = uuid.New().String()
+	})
+
+	It("routes envelopes to the request whose GUID they carry", func() {
+		mine := hub.Subscribe()
+		hub.Route(mine, guid)
+		theirs := hub.Subscribe()
+		hub.Route(theirs, other)
+
+		Expect(hub.Dispatch(httpStartStop("http://app.example.com/"+guid+".html", nil))).To(BeTrue())
+		Expect(hub.Dispatch(logMessage(`"GET /` + other + `.html HTTP/1.1" 200 response_time:0.01`))).To(BeTrue())
+
+		Expect(mine).To(Receive())
+		Expect(mine).NotTo(Receive())
+		Expect(theirs).To(Receive())
+		Expect(hub.Stats()).To(Equal(HubStats{Matched: 2}))
+	})
+
+	It("routes on the HttpStartStop request id", func() {
+		requestId := uuid.New()
+		ch := hub.Subscribe()
+		hub.Route(ch, requestId.String())
+
+		Expect(hub.Dispatch(httpStartStop("/custom", EventRequestId(requestId)))).To(BeTrue())
+		Expect(ch).To(Receive())
+	})
+
+	It("counts envelopes nobody is waiting for", func() {
+		ch := hub.Subscribe()
+		hub.Route(ch, guid)
+		hub.Unsubscribe(ch)
+
+		Expect(hub.Dispatch(httpStartStop("/"+guid+".html", nil))).To(BeFalse())
+		Expect(hub.Dispatch(logMessage("no ids here"))).To(BeFalse())
+		Expect(hub.Stats()).To(Equal(HubStats{Unmatched: 2}))
+	})
+
+	It("drops envelopes for subscribers that are not keeping up", func() {
+		ch := hub.Subscribe()
+		hub.Route(ch, guid)
+		for i := 0; i < HUB_BUFFER_SIZE+1; i++ {
+			hub.Dispatch(logMessage(guid))
+		}
+		Expect(hub.Stats()).To(Equal(HubStats{Matched: HUB_BUFFER_SIZE, Dropped: 1}))
+	})
+	Context("when a request is abandoned", func() {
+		var completedAt time.Time
+
+		BeforeEach(func() {
+			completedAt = clock.Now()
+			ch := hub.Subscribe()
+			hub.Route(ch, guid)
+			clock.Elapse(2 * time.Second)
+			hub.Abandon(ch, guid, completedAt, []events.Envelope_EventType{events.Envelope_HttpStartStop, events.Envelope_LogMessage}, []string{"app:thoth"})
+		})
+
+		It("reports envelopes that arrive late with the gap since the response", func() {
+			clock.Elapse(time.Second)
+			Expect(hub.Dispatch(logMessage(guid))).To(BeTrue())
+
+			Expect(deliveries).To(HaveLen(1))
+			Expect(deliveries[0].Guid).To(Equal(guid))
+			Expect(deliveries[0].Status).To(Equal(DeliveryLate))
+			Expect(deliveries[0].EventType).To(Equal(events.Envelope_LogMessage))
+			Expect(deliveries[0].Gap).To(Equal(3 * time.Second))
+			Expect(deliveries[0].Tags()).To(Equal([]string{"delivery:late", "event_type:log_message"}))
+			Expect(reported[0]).To(Equal([]string{"app:thoth"}))
+			Expect(hub.Stats()).To(Equal(HubStats{Late: 1}))
+		})
+
+		It("reports envelopes that never arrive as lost once the window passes", func() {
+			Expect(hub.Dispatch(logMessage(guid))).To(BeTrue())
+
+			clock.Elapse(9 * time.Second)
+			hub.Expire()
+			Expect(deliveries).To(HaveLen(1))
+
+			clock.Elapse(time.Second)
+			hub.Expire()
+			Expect(deliveries).To(HaveLen(2))
+			Expect(deliveries[1].Status).To(Equal(DeliveryLost))
+			Expect(deliveries[1].EventType).To(Equal(events.Envelope_HttpStartStop))
+			Expect(hub.Stats()).To(Equal(HubStats{Late: 1, Lost: 1}))
+
+			Expect(hub.Dispatch(httpStartStop("/"+guid+".html", nil))).To(BeFalse())
+		})
+
+		It("forgets the request once every envelope has arrived", func() {
+			Expect(hub.Dispatch(logMessage(guid))).To(BeTrue())
+			Expect(hub.Dispatch(httpStartStop("/"+guid+".html", nil))).To(BeTrue())
+			Expect(hub.Dispatch(logMessage(guid))).To(BeFalse())
+
+			clock.Elapse(time.Minute)
+			hub.Expire()
+			Expect(hub.Stats()).To(Equal(HubStats{Late: 2, Unmatched: 1}))
+		})
+
+		It("evicts the oldest request as lost when the table is full", func() {
+			for _, id := range []string{other, uuid.New().String()} {
+				ch := hub.Subscribe()
+				hub.Route(ch, id)
+				hub.Abandon(ch, id, completedAt, []events.Envelope_EventType{events.Envelope_LogMessage}, nil)
+			}
+
+			Expect(deliveries).To(HaveLen(2))
+			Expect(deliveries[0].Guid).To(Equal(guid))
+			Expect(deliveries[1].Guid).To(Equal(guid))
+			Expect(hub.Stats().Lost).To(Equal(uint64(2)))
+			Expect(hub.Dispatch(logMessage(other))).To(BeTrue())
+		})
+	})
+
+	Context("when a request completes", func() {
+		var ch chan *events.Envelope
+
+		BeforeEach(func() {
+			ch = hub.Subscribe()
+			hub.Route(ch, guid)
+		})
+
+		It("reports when each envelope type first arrived relative to its origin and the response", func() {
+			completedAt := clock.Now()
+			origin := completedAt.Add(-time.Second)
+			clock.Elapse(2 * time.Second)
+
+			timestamp := origin.UnixNano()
+			envelope := logMessage(guid)
+			envelope.Timestamp = &timestamp
+			hub.Dispatch(envelope)
+			clock.Elapse(time.Second)
+			hub.Dispatch(logMessage(guid))
+			hub.Dispatch(httpStartStop("/"+guid+".html", nil))
+
+			hub.Complete(ch, guid, completedAt, []string{"app:thoth"})
+			Expect(deliveries).To(HaveLen(2))
+
+			Expect(deliveries[0].Guid).To(Equal(guid))
+			Expect(deliveries[0].Status).To(Equal(DeliveryOnTime))
+			Expect(deliveries[0].EventType).To(Equal(events.Envelope_LogMessage))
+			Expect(deliveries[0].Gap).To(Equal(2 * time.Second))
+			Expect(deliveries[0].Latencies()).To(Equal([]Phase{
+				{Name: "origin", Duration: 3 * time.Second},
+				{Name: "response", Duration: 2 * time.Second},
+			}))
+			Expect(reported[0]).To(Equal([]string{"app:thoth"}))
+
+			Expect(deliveries[1].EventType).To(Equal(events.Envelope_HttpStartStop))
+			Expect(deliveries[1].Gap).To(Equal(3 * time.Second))
+			Expect(deliveries[1].Latencies()).To(Equal([]Phase{{Name: "response", Duration: 3 * time.Second}}))
+
+			Expect(hub.Dispatch(logMessage(guid))).To(BeFalse())
+		})
+
+		It("reports the envelopes that did arrive when it is abandoned", func() {
+			hub.Dispatch(httpStartStop("/"+guid+".html", nil))
+			<-ch
+
+			hub.Abandon(ch, guid, clock.Now(), []events.Envelope_EventType{events.Envelope_LogMessage}, nil)
+			Expect(deliveries).To(HaveLen(1))
+			Expect(deliveries[0].Status).To(Equal(DeliveryOnTime))
+			Expect(deliveries[0].EventType).To(Equal(events.Envelope_HttpStartStop))
+		})
+	})
+
+	It("does not report latencies for lost envelopes", func() {
+		Expect(EnvelopeDelivery{Status: DeliveryLost, Origin: clock.Now()}.Latencies()).To(BeEmpty())
+	})
+
+	It("counts envelopes already buffered on an abandoned channel as late", func() {
+		ch := hub.Subscribe()
+		hub.Route(ch, guid)
+		hub.Dispatch(logMessage(guid))
+
+		hub.Abandon(ch, guid, clock.Now(), []events.Envelope_EventType{events.Envelope_LogMessage}, nil)
+		Expect(deliveries).To(HaveLen(1))
+		Expect(deliveries[0].Status).To(Equal(DeliveryLate))
+		Expect(hub.Dispatch(logMessage(guid))).To(BeFalse())
+	})
+})
@@ -0,0 +1,252 @@
+package benchmark
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Stage struct {
+	Name        string
+	Concurrency int
+}
+
+func (s Stage) Tags() []string {
+	return []string{
+		"stage:" + s.Name,
+		"concurrency:" + strconv.Itoa(s.Concurrency),
+	}
+}
+
+// LoadProfile gives the number of concurrent requests to keep in flight at a
+// point in the profile. Profiles repeat once Duration has elapsed.
+type LoadProfile interface {
+	Stage(elapsed time.Duration) Stage
+	Duration() time.Duration
+}
+
+type RampProfile struct {
+	From, To int
+	Over     time.Duration
+}
+
+func (p RampProfile) Stage(elapsed time.Duration) Stage {
+	progress := float64(elapsed) / float64(p.Over)
+	return Stage{Name: "ramp", Concurrency: p.From + int(progress*float64(p.To-p.From))}
+}
+
+func (p RampProfile) Duration() time.Duration {
+	return p.Over
+}
+
+type StepProfile struct {
+	Levels []int
+	Each   time.Duration
+}
+
+func (p StepProfile) Stage(elapsed time.Duration) Stage {
+	i := int(elapsed / p.Each)
+	if i >= len(p.Levels) {
+		i = len(p.Levels) - 1
+	}
+	return Stage{Name: "step-" + strconv.Itoa(i+1), Concurrency: p.Levels[i]}
+}
+
+func (p StepProfile) Duration() time.Duration {
+	return time.Duration(len(p.Levels)) * p.Each
+}
+
+type BurstProfile struct {
+	Base, Burst int
+	Every, For  time.Duration
+}
+
+func (p BurstProfile) Stage(elapsed time.Duration) Stage {
+	if elapsed >= p.Every-p.For {
+		return Stage{Name: "burst", Concurrency: p.Burst}
+	}
+	return Stage{Name: "base", Concurrency: p.Base}
+}
+
+func (p BurstProfile) Duration() time.Duration {
+	return p.Every
+}
+
+// ParseLoadProfile reads profiles such as "ramp:from=1,to=20,over=10m",
+// "step:levels=1/5/10,each=2m" or "burst:base=2,burst=50,every=1m,for=10s".
+func ParseLoadProfile(s string) (LoadProfile, error) {
+	parts := strings.SplitN(s, ":", 2)
+	if len(parts) != 2 {
+		return nil, errors.New("invalid load profile: " + s)
+	}
+	options := map[string]string{}
+	for _, option := range strings.Split(parts[1], ",") {
+		kv := strings.SplitN(strings.TrimSpace(option), "=", 2)
+		if len(kv) != 2 {
+			return nil, errors.New("invalid load profile option: " + option)
+		}
+		options[kv[0]] = kv[1]
+	}
+
+	p := profileOptions{options: options}
+	var profile LoadProfile
+	switch strings.TrimSpace(parts[0]) {
+	case "ramp":
+		profile = RampProfile{From: p.int("from"), To: p.int("to"), Over: p.duration("over")}
+	case "step":
+		profile = StepProfile{Levels: p.ints("levels"), Each: p.duration("each")}
+	case "burst":
+		burst := BurstProfile{Base: p.int("base"), Burst: p.int("burst"), Every: p.duration("every"), For: p.duration("for")}
+		if p.err == nil && burst.For > burst.Every {
+			p.err = errors.New("burst cannot last longer than its period")
+		}
+		profile = burst
+	default:
+		return nil, errors.New("unknown load profile: " + parts[0])
+	}
+	if p.err != nil {
+		return nil, p.err
+	}
+	if profile.Duration() <= 0 {
+		return nil, errors.New("load profile must have a positive duration: " + s)
+	}
+	return profile, nil
+}
+
+type profileOptions struct {
+	options map[string]string
+	err     error
+}
+
+func (p *profileOptions) get(key string) string {
+	value, ok := p.options[key]
+	if !ok && p.err == nil {
+		p.err = errors.New("load profile is missing " + key)
+	}
+	return value
+}
+
+func (p *profileOptions) int(key string) int {
+	return p.parseInt(key, p.get(key))
+}
+
+func (p *profileOptions) ints(key string) []int {
+	ints := []int{}
+	for _, value := range strings.Split(p.get(key), "/") {
+		ints = append(ints, p.parseInt(key, value))
+	}
+	return ints
+}
+
+func (p *profileOptions) parseInt(key, value string) int {
+	i, err := strconv.Atoi(value)
+	if (err != nil || i < 0) && p.err == nil {
+		p.err = errors.New("invalid load profile " + key + ": " + value)
+	}
+	return i
+}
+
+func (p *profileOptions) duration(key string) time.Duration {
+	d, err := time.ParseDuration(p.get(key))
+	if err != nil && p.err == nil {
+		p.err = errors.New("invalid load profile " + key + ": " + p.options[key])
+	}
+	return d
+}
+
+const (
+	LOAD_DRIVER_MIN_BACKOFF = 100 * time.Millisecond
+	LOAD_DRIVER_MAX_BACKOFF = 5 * time.Second
+)
+
+// LoadDriver keeps as many requests in flight as the profile's current stage
+// asks for, re-evaluating the stage every adjustInterval. A worker whose
+// request could not be sent backs off before trying again.
+type LoadDriver struct {
+	profile        LoadProfile
+	clock          Clock
+	adjustInterval time.Duration
+
+	stage Stage
+	lock  sync.RWMutex
+}
+
+func NewLoadDriver(profile LoadProfile, clock Clock, adjustInterval time.Duration) *LoadDriver {
+	return &LoadDriver{profile: profile, clock: clock, adjustInterval: adjustInterval}
+}
+
+func (d *LoadDriver) Run(stop <-chan struct{}, fire func(stage Stage) error) {
+	wg := sync.WaitGroup{}
+	workers := []chan struct{}{}
+	defer func() {
+		for _, quit := range workers {
+			close(quit)
+		}
+		wg.Wait()
+	}()
+
+	start := d.clock.Now()
+	ticker := time.NewTicker(d.adjustInterval)
+	defer ticker.Stop()
+	for {
+		stage := d.profile.Stage(d.clock.Since(start) % d.profile.Duration())
+		d.lock.Lock()
+		d.stage = stage
+		d.lock.Unlock()
+
+		for len(workers) < stage.Concurrency {
+			quit := make(chan struct{})
+			workers = append(workers, quit)
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				d.work(quit, fire)
+			}()
+		}
+		for len(workers) > stage.Concurrency {
+			close(workers[len(workers)-1])
+			workers = workers[:len(workers)-1]
+		}
+
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+	}
+}
+
+func (d *LoadDriver) work(quit <-chan struct{}, fire func(stage Stage) error) {
+	backoff := time.Duration(0)
+	for {
+		select {
+		case <-quit:
+			return
+		default:
+		}
+
+		if fire(d.Stage()) == nil {
+			backoff = 0
+			continue
+		}
+
+		if backoff = 2 * backoff; backoff < LOAD_DRIVER_MIN_BACKOFF {
+			backoff = LOAD_DRIVER_MIN_BACKOFF
+		} else if backoff > LOAD_DRIVER_MAX_BACKOFF {
+			backoff = LOAD_DRIVER_MAX_BACKOFF
+		}
+		select {
+		case <-d.clock.After(backoff):
+		case <-quit:
+			return
+		}
+	}
+}
+
+func (d *LoadDriver) Stage() Stage {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	return d.stage
+}
@@ -0,0 +1,143 @@
+package benchmark_test
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	. "github.com/cloudfoundry-incubator/thoth/benchmark"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) Since(t time.Time) time.Duration        { return time.Since(t) }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+var _ = Describe("LoadProfile", func() {
+	Describe("ParseLoadProfile()", func() {
+		It("parses a linear ramp", func() {
+			profile, err := ParseLoadProfile("ramp:from=1,to=21,over=10m")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(profile.Duration()).To(Equal(10 * time.Minute))
+			Expect(profile.Stage(0)).To(Equal(Stage{Name: "ramp", Concurrency: 1}))
+			Expect(profile.Stage(5 * time.Minute)).To(Equal(Stage{Name: "ramp", Concurrency: 11}))
+		})
+
+		It("parses stepped plateaus", func() {
+			profile, err := ParseLoadProfile("step:levels=1/5/10,each=2m")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(profile.Duration()).To(Equal(6 * time.Minute))
+			Expect(profile.Stage(time.Minute)).To(Equal(Stage{Name: "step-1", Concurrency: 1}))
+			Expect(profile.Stage(5 * time.Minute)).To(Equal(Stage{Name: "step-3", Concurrency: 10}))
+		})
+
+		It("parses periodic bursts", func() {
+			profile, err := ParseLoadProfile("burst:base=2,burst=50,every=1m,for=10s")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(profile.Duration()).To(Equal(time.Minute))
+			Expect(profile.Stage(30 * time.Second)).To(Equal(Stage{Name: "base", Concurrency: 2}))
+			Expect(profile.Stage(55 * time.Second)).To(Equal(Stage{Name: "burst", Concurrency: 50}))
+		})
+
+		It("rejects unknown profiles and missing options", func() {
+			_, err := ParseLoadProfile("spike:to=10")
+			Expect(err).To(HaveOccurred())
+
+			_, err = ParseLoadProfile("ramp:from=1,to=10")
+			Expect(err).To(MatchError(ContainSubstring("over")))
+
+			_, err = ParseLoadProfile("burst:base=1,burst=5,every=10s,for=1m")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("Stage", func() {
+		It("tags samples with the stage and concurrency", func() {
+			Expect(Stage{Name: "step-2", Concurrency: 5}.Tags()).To(Equal([]string{"stage:step-2", "concurrency:5"}))
+		})
+	})
+
+	Describe("LoadDriver", func() {
+		It("keeps the stage's number of requests in flight", func() {
+			var lock sync.Mutex
+			inFlight, maxInFlight := 0, 0
+			stop := make(chan struct{})
+			done := make(chan struct{})
+
+			driver := NewLoadDriver(StepProfile{Levels: []int{3}, Each: time.Minute}, realClock{}, 10*time.Millisecond)
+			go func() {
+				driver.Run(stop, func(stage Stage) error {
+					lock.Lock()
+					inFlight++
+					if inFlight > maxInFlight {
+						maxInFlight = inFlight
+					}
+					lock.Unlock()
+					time.Sleep(5 * time.Millisecond)
+					lock.Lock()
+					inFlight--
+					lock.Unlock()
+					return nil
+				})
+				close(done)
+			}()
+
+			Eventually(func() int {
+				lock.Lock()
+				defer lock.Unlock()
+				return maxInFlight
+			}).Should(Equal(3))
+			Consistently(func() int {
+				lock.Lock()
+				defer lock.Unlock()
+				return maxInFlight
+			}, 50*time.Millisecond).Should(Equal(3))
+
+			close(stop)
+			Eventually(done).Should(BeClosed())
+		})
+
+		It("backs off after requests that could not be sent", func() {
+			var lock sync.Mutex
+			fired := 0
+			stop := make(chan struct{})
+			done := make(chan struct{})
+			clock := NewFakeClock()
+
+			driver := NewLoadDriver(StepProfile{Levels: []int{1}, Each: time.Minute}, clock, 10*time.Millisecond)
+			go func() {
+				driver.Run(stop, func(stage Stage) error {
+					lock.Lock()
+					defer lock.Unlock()
+					fired++
+					return errors.New("connection refused")
+				})
+				close(done)
+			}()
+			firedCount := func() int {
+				lock.Lock()
+				defer lock.Unlock()
+				return fired
+			}
+
+			Eventually(clock.Timers).Should(Equal(1))
+			Consistently(firedCount, 50*time.Millisecond).Should(Equal(1))
+
+			clock.Elapse(LOAD_DRIVER_MIN_BACKOFF)
+			Eventually(firedCount).Should(Equal(2))
+			Eventually(clock.Timers).Should(Equal(1))
+
+			clock.Elapse(LOAD_DRIVER_MIN_BACKOFF)
+			Consistently(firedCount, 50*time.Millisecond).Should(Equal(2))
+			clock.Elapse(LOAD_DRIVER_MIN_BACKOFF)
+			Eventually(firedCount).Should(Equal(3))
+
+			close(stop)
+			Eventually(done).Should(BeClosed())
+		})
+	})
+})
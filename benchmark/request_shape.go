@@ -0,0 +1,126 @@
+package benchmark
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const GUID_PLACEHOLDER = "{guid}"
+
+// RequestShape describes the request sent to the benchmarked app. The path
+// must embed the request GUID so the gorouter envelopes can be correlated.
+type RequestShape struct {
+	Method       string
+	Headers      http.Header
+	Body         []byte
+	PathTemplate string
+	SizeClass    string
+}
+
+var DefaultRequestShape = RequestShape{
+	Method:       "GET",
+	PathTemplate: "/" + GUID_PLACEHOLDER + ".html",
+}
+
+func NewRequestShape(method string, headers http.Header, body []byte, bodySize int, pathTemplate string) (RequestShape, error) {
+	if method == "" {
+		method = DefaultRequestShape.Method
+	}
+	if pathTemplate == "" {
+		pathTemplate = DefaultRequestShape.PathTemplate
+	}
+	if !strings.Contains(pathTemplate, GUID_PLACEHOLDER) {
+		return RequestShape{}, errors.New("request path template must contain " + GUID_PLACEHOLDER)
+	}
+	if !strings.HasPrefix(pathTemplate, "/") {
+		pathTemplate = "/" + pathTemplate
+	}
+	if body == nil && bodySize > 0 {
+		body = bytes.Repeat([]byte("x"), bodySize)
+	}
+
+	return RequestShape{
+		Method:       strings.ToUpper(method),
+		Headers:      headers,
+		Body:         body,
+		PathTemplate: pathTemplate,
+	}, nil
+}
+
+func (rs RequestShape) WithHeader(name, value string) RequestShape {
+	headers := http.Header{}
+	for n, values := range rs.Headers {
+		headers[n] = append([]string{}, values...)
+	}
+	headers.Set(name, value)
+	rs.Headers = headers
+	return rs
+}
+
+// ForResponseSize points the request at the benchmarked-app file of that size.
+func (rs RequestShape) ForResponseSize(size ResponseSize) RequestShape {
+	rs.PathTemplate = "/sizes/" + strconv.Itoa(size.Bytes) + rs.PathTemplate
+	rs.SizeClass = size.Name
+	return rs
+}
+
+func (rs RequestShape) Path(guid string) string {
+	return strings.Replace(rs.PathTemplate, GUID_PLACEHOLDER, guid, -1)
+}
+
+func (rs RequestShape) NewRequest(appUrl, guid string) (*http.Request, error) {
+	var body io.Reader
+	if len(rs.Body) > 0 {
+		body = bytes.NewReader(rs.Body)
+	}
+
+	req, err := http.NewRequest(rs.Method, appUrl+rs.Path(guid), body)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range rs.Headers {
+		for _, value := range values {
+			req.Header.Add(name, value)
+		}
+	}
+	if host := req.Header.Get("Host"); host != "" {
+		req.Host = host
+	}
+	return req, nil
+}
+
+// ParseHeaders reads a JSON object of header names to values. Headers in the
+// form "Name: value", one per line, are accepted too.
+func ParseHeaders(s string) (http.Header, error) {
+	headers := http.Header{}
+	if strings.HasPrefix(strings.TrimSpace(s), "{") {
+		values := map[string]string{}
+		if err := json.Unmarshal([]byte(s), &values); err != nil {
+			return nil, err
+		}
+		for name, value := range values {
+			if strings.TrimSpace(name) == "" {
+				return nil, errors.New("invalid header: empty name")
+			}
+			headers.Add(strings.TrimSpace(name), value)
+		}
+		return headers, nil
+	}
+
+	for _, header := range strings.Split(s, "\n") {
+		if strings.TrimSpace(header) == "" {
+			continue
+		}
+		parts := strings.SplitN(header, ":", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return nil, errors.New("invalid header: " + header)
+		}
+		headers.Add(strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]))
+	}
+	return headers, nil
+}
@@ -0,0 +1,107 @@
+package benchmark_test
+
+import (
+	"io/ioutil"
+	"net/http"
+
+	. "github.com/cloudfoundry-incubator/thoth/benchmark"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("RequestShape", func() {
+	Describe("NewRequestShape()", func() {
+		It("defaults to GET /{guid}.html", func() {
+			shape, err := NewRequestShape("", nil, nil, 0, "")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(shape.Method).To(Equal("GET"))
+			Expect(shape.Path("abc")).To(Equal("/abc.html"))
+		})
+
+		It("requires the guid placeholder in the path", func() {
+			_, err := NewRequestShape("GET", nil, nil, 0, "/static.html")
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("generates a body of the requested size", func() {
+			shape, err := NewRequestShape("post", nil, nil, 16, "api/{guid}")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(shape.Method).To(Equal("POST"))
+			Expect(shape.Body).To(HaveLen(16))
+			Expect(shape.Path("abc")).To(Equal("/api/abc"))
+		})
+
+		It("prefers an explicit body over a generated one", func() {
+			shape, err := NewRequestShape("PUT", nil, []byte("hello"), 16, "")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(shape.Body)).To(Equal("hello"))
+		})
+	})
+
+	Describe("NewRequest()", func() {
+		It("builds the request with the method, headers and body", func() {
+			headers, err := ParseHeaders("Content-Type: application/json\nX-Thoth: a:b")
+			Expect(err).NotTo(HaveOccurred())
+			shape, err := NewRequestShape("POST", headers, []byte(`{"a":1}`), 0, "/api/{guid}/{guid}")
+			Expect(err).NotTo(HaveOccurred())
+
+			req, err := shape.NewRequest("http://example.com", "abc")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(req.Method).To(Equal("POST"))
+			Expect(req.URL.String()).To(Equal("http://example.com/api/abc/abc"))
+			Expect(req.Header.Get("Content-Type")).To(Equal("application/json"))
+			Expect(req.Header.Get("X-Thoth")).To(Equal("a:b"))
+			body, _ := ioutil.ReadAll(req.Body)
+			Expect(string(body)).To(Equal(`{"a":1}`))
+		})
+	})
+
+	Describe("WithHeader()", func() {
+		It("sets the header without changing the original shape", func() {
+			headers, _ := ParseHeaders("X-Thoth: a")
+			shape, _ := NewRequestShape("GET", headers, nil, 0, "")
+
+			withInstance := shape.WithHeader("X-CF-APP-INSTANCE", "guid:1")
+			Expect(withInstance.Headers.Get("X-CF-APP-INSTANCE")).To(Equal("guid:1"))
+			Expect(withInstance.Headers.Get("X-Thoth")).To(Equal("a"))
+			Expect(shape.Headers.Get("X-CF-APP-INSTANCE")).To(BeEmpty())
+		})
+	})
+
+	Describe("ParseHeaders()", func() {
+		It("parses an empty string", func() {
+			headers, err := ParseHeaders("")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(headers).To(Equal(http.Header{}))
+		})
+
+		It("keeps parameterised values on a single header", func() {
+			headers, err := ParseHeaders("Content-Type: text/plain; charset=utf-8\nX-Thoth: a")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(headers).To(Equal(http.Header{
+				"Content-Type": {"text/plain; charset=utf-8"},
+				"X-Thoth":      {"a"},
+			}))
+		})
+
+		It("parses a JSON object of headers", func() {
+			headers, err := ParseHeaders(`{"Content-Type": "text/plain; charset=utf-8", "x-thoth": "a"}`)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(headers).To(Equal(http.Header{
+				"Content-Type": {"text/plain; charset=utf-8"},
+				"X-Thoth":      {"a"},
+			}))
+		})
+
+		It("rejects an invalid JSON object", func() {
+			_, err := ParseHeaders(`{"Content-Type": 1}`)
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("rejects headers without a value separator", func() {
+			_, err := ParseHeaders("Content-Type")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
@@ -0,0 +1,87 @@
+package benchmark
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type ResponseSize struct {
+	Name  string
+	Bytes int
+}
+
+var sizeUnits = []struct {
+	suffix string
+	bytes  int
+}{
+	{"MB", 1024 * 1024},
+	{"KB", 1024},
+	{"B", 1},
+}
+
+// ParseResponseSizes reads a comma separated list such as "0B,10KB,1MB".
+func ParseResponseSizes(s string) ([]ResponseSize, error) {
+	sizes := []ResponseSize{}
+	for _, name := range strings.Split(s, ",") {
+		name = strings.ToUpper(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		size, err := parseResponseSize(name)
+		if err != nil {
+			return nil, err
+		}
+		sizes = append(sizes, size)
+	}
+	return sizes, nil
+}
+
+func parseResponseSize(name string) (ResponseSize, error) {
+	multiplier := 1
+	number := name
+	for _, unit := range sizeUnits {
+		if strings.HasSuffix(name, unit.suffix) {
+			multiplier = unit.bytes
+			number = strings.TrimSuffix(name, unit.suffix)
+			break
+		}
+	}
+
+	n, err := strconv.Atoi(number)
+	if err != nil || n < 0 {
+		return ResponseSize{}, errors.New("invalid response size: " + name)
+	}
+	if number == name {
+		name += "B"
+	}
+	return ResponseSize{Name: name, Bytes: n * multiplier}, nil
+}
+
+// SizeSweep hands out the request shape for the next response size in turn.
+type SizeSweep struct {
+	shapes []RequestShape
+	next   int
+	lock   sync.Mutex
+}
+
+func NewSizeSweep(shape RequestShape, sizes []ResponseSize) *SizeSweep {
+	shapes := make([]RequestShape, len(sizes))
+	for i, size := range sizes {
+		shapes[i] = shape.ForResponseSize(size)
+	}
+	if len(shapes) == 0 {
+		shapes = []RequestShape{shape}
+	}
+	return &SizeSweep{shapes: shapes}
+}
+
+func (ss *SizeSweep) Next() RequestShape {
+	ss.lock.Lock()
+	defer ss.lock.Unlock()
+
+	shape := ss.shapes[ss.next]
+	ss.next = (ss.next + 1) % len(ss.shapes)
+	return shape
+}
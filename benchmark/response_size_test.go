@@ -0,0 +1,50 @@
+package benchmark_test
+
+import (
+	. "github.com/cloudfoundry-incubator/thoth/benchmark"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ResponseSize", func() {
+	Describe("ParseResponseSizes()", func() {
+		It("parses byte, kilobyte and megabyte sizes", func() {
+			sizes, err := ParseResponseSizes("0, 10kb,1MB,512")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(sizes).To(Equal([]ResponseSize{
+				{Name: "0B", Bytes: 0},
+				{Name: "10KB", Bytes: 10 * 1024},
+				{Name: "1MB", Bytes: 1024 * 1024},
+				{Name: "512B", Bytes: 512},
+			}))
+		})
+
+		It("rejects unknown sizes", func() {
+			_, err := ParseResponseSizes("10GB")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("SizeSweep", func() {
+		It("rotates through the sizes", func() {
+			sweep := NewSizeSweep(DefaultRequestShape, []ResponseSize{{Name: "0B", Bytes: 0}, {Name: "10KB", Bytes: 10240}})
+
+			first := sweep.Next()
+			Expect(first.SizeClass).To(Equal("0B"))
+			Expect(first.Path("abc")).To(Equal("/sizes/0/abc.html"))
+
+			second := sweep.Next()
+			Expect(second.SizeClass).To(Equal("10KB"))
+			Expect(second.Path("abc")).To(Equal("/sizes/10240/abc.html"))
+
+			Expect(sweep.Next().SizeClass).To(Equal("0B"))
+		})
+
+		It("always uses the base shape without sizes", func() {
+			sweep := NewSizeSweep(DefaultRequestShape, nil)
+			Expect(sweep.Next()).To(Equal(DefaultRequestShape))
+			Expect(sweep.Next()).To(Equal(DefaultRequestShape))
+		})
+	})
+})
@@ -0,0 +1,32 @@
+package benchmark
+
+import "github.com/cloudfoundry/sonde-go/events"
+
+// RouterMetadata identifies the gorouter VM that emitted the envelopes for a
+// request, so a slow router behind the load balancer can be singled out.
+type RouterMetadata struct {
+	Deployment string
+	Job        string
+	Index      string
+	Ip         string
+}
+
+func NewRouterMetadata(envelope *events.Envelope) RouterMetadata {
+	return RouterMetadata{
+		Deployment: envelope.GetDeployment(),
+		Job:        envelope.GetJob(),
+		Index:      envelope.GetIndex(),
+		Ip:         envelope.GetIp(),
+	}
+}
+
+func (rm RouterMetadata) Tags() []string {
+	tags := []string{}
+	if rm.Job != "" {
+		tags = append(tags, "router_job:"+rm.Job)
+	}
+	if rm.Index != "" {
+		tags = append(tags, "router_index:"+rm.Index)
+	}
+	return tags
+}
@@ -0,0 +1,54 @@
+package benchmark
+
+import (
+	"sync"
+	"time"
+)
+
+// Scheduler issues requests open-loop at a constant rate. Each request is
+// handed the time it was meant to start, so latency can be corrected for
+// coordinated omission when the scheduler falls behind or maxInFlight is hit.
+type Scheduler struct {
+	interval time.Duration
+	slots    chan struct{}
+	clock    Clock
+}
+
+func NewScheduler(rate float64, maxInFlight int, clock Clock) *Scheduler {
+	if maxInFlight <= 0 {
+		maxInFlight = 1
+	}
+	return &Scheduler{
+		interval: time.Duration(float64(time.Second) / rate),
+		slots:    make(chan struct{}, maxInFlight),
+		clock:    clock,
+	}
+}
+
+func (s *Scheduler) Run(stop <-chan struct{}, fire func(scheduledAt time.Time)) {
+	wg := sync.WaitGroup{}
+	defer wg.Wait()
+
+	next := s.clock.Now()
+	for {
+		select {
+		case <-s.clock.After(next.Sub(s.clock.Now())):
+		case <-stop:
+			return
+		}
+
+		select {
+		case s.slots <- struct{}{}:
+		case <-stop:
+			return
+		}
+
+		wg.Add(1)
+		go func(scheduledAt time.Time) {
+			defer wg.Done()
+			defer func() { <-s.slots }()
+			fire(scheduledAt)
+		}(next)
+		next = next.Add(s.interval)
+	}
+}
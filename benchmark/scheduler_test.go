@@ -0,0 +1,89 @@
+package benchmark_test
+
+import (
+	"sync"
+	"time"
+
+	. "github.com/cloudfoundry-incubator/thoth/benchmark"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Scheduler", func() {
+	var (
+		lock      sync.Mutex
+		scheduled []time.Time
+		clock     *FakeClock
+		stop      chan struct{}
+		done      chan struct{}
+	)
+
+	run := func(scheduler *Scheduler, fire func()) {
+		go func() {
+			scheduler.Run(stop, func(scheduledAt time.Time) {
+				lock.Lock()
+				scheduled = append(scheduled, scheduledAt)
+				lock.Unlock()
+				fire()
+			})
+			close(done)
+		}()
+	}
+
+	count := func() int {
+		lock.Lock()
+		defer lock.Unlock()
+		return len(scheduled)
+	}
+
+	BeforeEach(func() {
+		scheduled = nil
+		clock = NewFakeClock()
+		stop = make(chan struct{})
+		done = make(chan struct{})
+	})
+
+	It("issues requests at the configured rate regardless of outstanding requests", func() {
+		start := clock.Now()
+		block := make(chan struct{})
+		run(NewScheduler(100, 100, clock), func() { <-block })
+
+		Eventually(count).Should(Equal(1))
+		for i := 2; i <= 5; i++ {
+			Eventually(clock.Timers).Should(Equal(1))
+			clock.Elapse(10 * time.Millisecond)
+			Eventually(count).Should(Equal(i))
+		}
+		close(block)
+		close(stop)
+		Eventually(done).Should(BeClosed())
+
+		lock.Lock()
+		defer lock.Unlock()
+		for i := range scheduled {
+			Expect(scheduled[i]).To(Equal(start.Add(time.Duration(i) * 10 * time.Millisecond)))
+		}
+	})
+
+	It("keeps the intended start times when in-flight requests hold it back", func() {
+		start := clock.Now()
+		block := make(chan struct{})
+		run(NewScheduler(100, 1, clock), func() { <-block })
+
+		Eventually(count).Should(Equal(1))
+		Eventually(clock.Timers).Should(Equal(1))
+		clock.Elapse(50 * time.Millisecond)
+		Consistently(count, 50*time.Millisecond).Should(Equal(1))
+
+		block <- struct{}{}
+		Eventually(count).Should(Equal(2))
+		close(stop)
+		close(block)
+		Eventually(done).Should(BeClosed())
+
+		lock.Lock()
+		defer lock.Unlock()
+		Expect(scheduled[1]).To(Equal(start.Add(10 * time.Millisecond)))
+	})
+})
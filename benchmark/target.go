@@ -0,0 +1,106 @@
+package benchmark
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Target is a benchmarked app. Routes are probed in turn; when none are
+// configured they are looked up from the app.
+type Target struct {
+	App    string   `json:"app"`
+	Routes []string `json:"routes"`
+	Tags   []string `json:"tags"`
+
+	Guid string `json:"-"`
+
+	instances    []int
+	next         int
+	nextInstance int
+	lock         sync.Mutex
+}
+
+// ParseTargets reads a JSON list of targets. A plain comma separated list of
+// app names is accepted too.
+func ParseTargets(s string) ([]*Target, error) {
+	s = strings.TrimSpace(s)
+	targets := []*Target{}
+	if strings.HasPrefix(s, "[") {
+		if err := json.Unmarshal([]byte(s), &targets); err != nil {
+			return nil, err
+		}
+	} else {
+		for _, app := range strings.Split(s, ",") {
+			if app = strings.TrimSpace(app); app != "" {
+				targets = append(targets, &Target{App: app})
+			}
+		}
+	}
+
+	for _, target := range targets {
+		if target.App == "" {
+			return nil, errors.New("target is missing an app name")
+		}
+	}
+	return targets, nil
+}
+
+func (t *Target) NextRoute() string {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if len(t.Routes) == 0 {
+		return ""
+	}
+	route := t.Routes[t.next]
+	t.next = (t.next + 1) % len(t.Routes)
+	return route
+}
+
+func (t *Target) SetInstances(instances []int) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.instances = instances
+}
+
+// NextInstance returns the next running instance to route a request to with
+// the X-CF-APP-INSTANCE header, or false when no instances are known.
+func (t *Target) NextInstance() (int, bool) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if len(t.instances) == 0 {
+		return 0, false
+	}
+	t.nextInstance = t.nextInstance % len(t.instances)
+	instance := t.instances[t.nextInstance]
+	t.nextInstance++
+	return instance, true
+}
+
+func (t *Target) InstanceHeader(index int) string {
+	return t.Guid + ":" + strconv.Itoa(index)
+}
+
+func (t *Target) URL(route string) string {
+	if strings.HasPrefix(route, "http://") || strings.HasPrefix(route, "https://") {
+		return strings.TrimSuffix(route, "/")
+	}
+	return "http://" + strings.TrimSuffix(route, "/")
+}
+
+// RouteHasPath reports whether the route is bound to a path rather than to
+// the whole host, e.g. example.com/api.
+func RouteHasPath(route string) bool {
+	route = strings.TrimPrefix(strings.TrimPrefix(route, "http://"), "https://")
+	i := strings.Index(route, "/")
+	return i >= 0 && strings.Trim(route[i:], "/") != ""
+}
+
+func (t *Target) RouteTags(route string) []string {
+	tags := []string{"app:" + t.App, "route:" + route}
+	return append(tags, t.Tags...)
+}
@@ -0,0 +1,67 @@
+package benchmark_test
+
+import (
+	. "github.com/cloudfoundry-incubator/thoth/benchmark"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Target", func() {
+	Describe("ParseTargets()", func() {
+		It("parses a list of app names", func() {
+			targets, err := ParseTargets("benchmarked-app, other-app")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(targets).To(HaveLen(2))
+			Expect(targets[0].App).To(Equal("benchmarked-app"))
+			Expect(targets[1].App).To(Equal("other-app"))
+		})
+
+		It("parses targets with routes and tags", func() {
+			targets, err := ParseTargets(`[{"app":"windows-app","routes":["a.example.com","b.example.com"],"tags":["stack:windows"]}]`)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(targets).To(HaveLen(1))
+			Expect(targets[0].Routes).To(Equal([]string{"a.example.com", "b.example.com"}))
+			Expect(targets[0].Tags).To(Equal([]string{"stack:windows"}))
+		})
+
+		It("requires an app name", func() {
+			_, err := ParseTargets(`[{"routes":["a.example.com"]}]`)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	It("cycles through its running instances", func() {
+		target := &Target{App: "app", Guid: "app-guid"}
+		_, ok := target.NextInstance()
+		Expect(ok).To(BeFalse())
+
+		target.SetInstances([]int{0, 2})
+		instance, _ := target.NextInstance()
+		Expect(target.InstanceHeader(instance)).To(Equal("app-guid:0"))
+		instance, _ = target.NextInstance()
+		Expect(instance).To(Equal(2))
+
+		target.SetInstances([]int{1})
+		instance, _ = target.NextInstance()
+		Expect(instance).To(Equal(1))
+	})
+
+	It("rotates through its routes and tags them", func() {
+		target := &Target{App: "app", Routes: []string{"a.example.com", "https://b.example.com/"}, Tags: []string{"stack:windows"}}
+
+		route := target.NextRoute()
+		Expect(target.URL(route)).To(Equal("http://a.example.com"))
+		Expect(target.RouteTags(route)).To(Equal([]string{"app:app", "route:a.example.com", "stack:windows"}))
+
+		Expect(target.URL(target.NextRoute())).To(Equal("https://b.example.com"))
+		Expect(target.NextRoute()).To(Equal("a.example.com"))
+	})
+
+	It("knows which routes are bound to a path", func() {
+		Expect(RouteHasPath("a.example.com")).To(BeFalse())
+		Expect(RouteHasPath("https://a.example.com/")).To(BeFalse())
+		Expect(RouteHasPath("a.example.com/api")).To(BeTrue())
+		Expect(RouteHasPath("https://a.example.com/api/")).To(BeTrue())
+	})
+})
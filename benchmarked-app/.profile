@@ -0,0 +1,16 @@
+#!/usr/bin/env bash
+# Generates the files served under /sizes/<bytes>/ for thoth's response-size sweep.
+# RESPONSE_SIZES uses the same format as THOTH_RESPONSE_SIZES, e.g. "0B,10KB,1MB,10MB".
+
+sizes_dir="$HOME/public/sizes"
+mkdir -p "$sizes_dir"
+
+for size in $(echo "${RESPONSE_SIZES:-0B,10KB,1MB,10MB}" | tr ',' ' ' | tr '[:lower:]' '[:upper:]'); do
+  case "$size" in
+    *MB) bytes=$(( ${size%MB} * 1024 * 1024 )) ;;
+    *KB) bytes=$(( ${size%KB} * 1024 )) ;;
+    *B) bytes=${size%B} ;;
+    *) bytes=$size ;;
+  esac
+  head -c "$bytes" /dev/urandom > "$sizes_dir/$bytes"
+done
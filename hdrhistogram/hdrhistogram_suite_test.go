@@ -0,0 +1,13 @@
+package hdrhistogram_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestHdrhistogram(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Hdrhistogram Suite")
+}
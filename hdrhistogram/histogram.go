@@ -0,0 +1,137 @@
+package hdrhistogram
+
+import (
+	"errors"
+	"math"
+)
+
+// Histogram is a High Dynamic Range histogram: values between 1 and
+// highestTrackableValue are recorded with a relative error of at most
+// 10^-significantFigures, using log-linear buckets.
+type Histogram struct {
+	highestTrackableValue       int64
+	subBucketHalfCountMagnitude uint
+	subBucketHalfCount          int64
+	subBucketCount              int64
+	subBucketMask               int64
+	counts                      []int64
+	totalCount                  int64
+}
+
+func New(highestTrackableValue int64, significantFigures int) (*Histogram, error) {
+	if significantFigures < 1 || significantFigures > 5 {
+		return nil, errors.New("significant figures must be between 1 and 5")
+	}
+	if highestTrackableValue < 2 {
+		return nil, errors.New("highest trackable value must be at least 2")
+	}
+
+	largestValueWithSingleUnitResolution := 2 * int64(math.Pow10(significantFigures))
+	subBucketCountMagnitude := uint(math.Ceil(math.Log2(float64(largestValueWithSingleUnitResolution))))
+	subBucketHalfCountMagnitude := subBucketCountMagnitude - 1
+	subBucketCount := int64(1) << subBucketCountMagnitude
+
+	bucketsNeeded := int64(1)
+	for smallestUntrackableValue := subBucketCount; smallestUntrackableValue <= highestTrackableValue; smallestUntrackableValue <<= 1 {
+		bucketsNeeded++
+		if smallestUntrackableValue > math.MaxInt64/2 {
+			break
+		}
+	}
+
+	return &Histogram{
+		highestTrackableValue:       highestTrackableValue,
+		subBucketHalfCountMagnitude: subBucketHalfCountMagnitude,
+		subBucketHalfCount:          subBucketCount / 2,
+		subBucketCount:              subBucketCount,
+		subBucketMask:               subBucketCount - 1,
+		counts:                      make([]int64, (bucketsNeeded+1)*(subBucketCount/2)),
+	}, nil
+}
+
+func (h *Histogram) RecordValue(v int64) error {
+	if v < 0 || v > h.highestTrackableValue {
+		return errors.New("value out of range")
+	}
+	h.counts[h.countsIndexFor(v)]++
+	h.totalCount++
+	return nil
+}
+
+func (h *Histogram) TotalCount() int64 {
+	return h.totalCount
+}
+
+func (h *Histogram) ValueAtQuantile(q float64) int64 {
+	if h.totalCount == 0 {
+		return 0
+	}
+	if q > 100 {
+		q = 100
+	}
+
+	countAtQuantile := int64(q/100*float64(h.totalCount) + 0.5)
+	if countAtQuantile < 1 {
+		countAtQuantile = 1
+	}
+
+	var total int64
+	for i, count := range h.counts {
+		total += count
+		if total >= countAtQuantile {
+			return h.highestEquivalentValue(h.valueFromIndex(int64(i)))
+		}
+	}
+	return 0
+}
+
+func (h *Histogram) Reset() {
+	for i := range h.counts {
+		h.counts[i] = 0
+	}
+	h.totalCount = 0
+}
+
+func (h *Histogram) bucketIndex(v int64) int64 {
+	return int64(bitLength(v|h.subBucketMask)) - int64(h.subBucketHalfCountMagnitude+1)
+}
+
+func (h *Histogram) subBucketIndex(v, bucketIndex int64) int64 {
+	return v >> uint(bucketIndex)
+}
+
+func (h *Histogram) countsIndexFor(v int64) int64 {
+	bucketIndex := h.bucketIndex(v)
+	subBucketIndex := h.subBucketIndex(v, bucketIndex)
+	return (bucketIndex+1)<<h.subBucketHalfCountMagnitude + subBucketIndex - h.subBucketHalfCount
+}
+
+func (h *Histogram) valueFromIndex(index int64) int64 {
+	bucketIndex := (index >> h.subBucketHalfCountMagnitude) - 1
+	subBucketIndex := (index & (h.subBucketHalfCount - 1)) + h.subBucketHalfCount
+	if bucketIndex < 0 {
+		subBucketIndex -= h.subBucketHalfCount
+		bucketIndex = 0
+	}
+	return subBucketIndex << uint(bucketIndex)
+}
+
+func (h *Histogram) highestEquivalentValue(v int64) int64 {
+	bucketIndex := h.bucketIndex(v)
+	subBucketIndex := h.subBucketIndex(v, bucketIndex)
+	lowestEquivalentValue := subBucketIndex << uint(bucketIndex)
+
+	rangeMagnitude := bucketIndex
+	if subBucketIndex >= h.subBucketCount {
+		rangeMagnitude++
+	}
+	return lowestEquivalentValue + int64(1)<<uint(rangeMagnitude) - 1
+}
+
+func bitLength(v int64) uint {
+	var n uint
+	for u := uint64(v); u != 0; u >>= 1 {
+		n++
+	}
+	return n
+}
@@ -0,0 +1,55 @@
+package hdrhistogram_test
+
+import (
+	. "github.com/cloudfoundry-incubator/thoth/hdrhistogram"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Histogram", func() {
+	var h *Histogram
+
+	BeforeEach(func() {
+		var err error
+		h, err = New(3600*1000*1000, 3)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("is empty to begin with", func() {
+		Expect(h.TotalCount()).To(BeZero())
+		Expect(h.ValueAtQuantile(99)).To(BeZero())
+	})
+
+	It("rejects values outside the trackable range", func() {
+		Expect(h.RecordValue(-1)).NotTo(Succeed())
+		Expect(h.RecordValue(3600*1000*1000 + 1)).NotTo(Succeed())
+	})
+
+	It("reports exact values below the single unit resolution limit", func() {
+		for v := int64(1); v <= 100; v++ {
+			Expect(h.RecordValue(v)).To(Succeed())
+		}
+
+		Expect(h.TotalCount()).To(Equal(int64(100)))
+		Expect(h.ValueAtQuantile(50)).To(Equal(int64(50)))
+		Expect(h.ValueAtQuantile(99)).To(Equal(int64(99)))
+		Expect(h.ValueAtQuantile(100)).To(Equal(int64(100)))
+	})
+
+	It("reports large values within the configured precision", func() {
+		for v := int64(1); v <= 10000; v++ {
+			Expect(h.RecordValue(v * 1000)).To(Succeed())
+		}
+
+		Expect(h.ValueAtQuantile(50)).To(BeNumerically("~", 5000*1000, 5000))
+		Expect(h.ValueAtQuantile(99.9)).To(BeNumerically("~", 9990*1000, 9990))
+		Expect(h.ValueAtQuantile(100)).To(BeNumerically("~", 10000*1000, 10000))
+	})
+
+	It("forgets everything on reset", func() {
+		Expect(h.RecordValue(42)).To(Succeed())
+		h.Reset()
+		Expect(h.TotalCount()).To(BeZero())
+	})
+})
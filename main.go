@@ -1,24 +1,24 @@
 package main
 
 import (
-	"bytes"
-	"encoding/json"
 	"errors"
 	"flag"
-	"io/ioutil"
 	"net/http"
 	"os"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	cf_lager "github.com/cloudfoundry-incubator/cf-lager"
 	"github.com/cloudfoundry-incubator/thoth/assistant"
 	"github.com/cloudfoundry-incubator/thoth/benchmark"
+	"github.com/cloudfoundry-incubator/thoth/metrics"
 	"github.com/cloudfoundry/sonde-go/events"
 	"github.com/pivotal-golang/lager"
 	"github.com/tedsuo/ifrit"
 	"github.com/tedsuo/ifrit/grouper"
+	"github.com/tedsuo/ifrit/http_server"
 	"github.com/tedsuo/ifrit/sigmon"
 )
 
@@ -30,18 +30,61 @@ var (
 	space             = os.Getenv("CF_SPACE")
 	skipSSLValidation = os.Getenv("CF_SKIP_SSL_VALIDATION") == "true"
 	appName           = os.Getenv("CF_APP_NAME")
+	targetsString     = os.Getenv("THOTH_TARGETS")
+	targetInstances   = os.Getenv("THOTH_TARGET_INSTANCES") == "true"
 	deploymentName    = os.Getenv("CF_DEPLOYMENT_NAME")
 	threadsString     = os.Getenv("THOTH_THREADS")
+	sinksString       = os.Getenv("THOTH_SINKS")
+	port              = os.Getenv("PORT")
+	statsdAddress     = os.Getenv("THOTH_STATSD_ADDRESS")
+	influxDestination = os.Getenv("THOTH_INFLUX_DESTINATION")
+	requestMethod     = os.Getenv("THOTH_REQUEST_METHOD")
+	requestHeaders    = os.Getenv("THOTH_REQUEST_HEADERS")
+	requestBody       = os.Getenv("THOTH_REQUEST_BODY")
+	requestBodySize   = os.Getenv("THOTH_REQUEST_BODY_SIZE")
+	requestPath       = os.Getenv("THOTH_REQUEST_PATH")
+	responseSizes     = os.Getenv("THOTH_RESPONSE_SIZES")
+	requestRateString = os.Getenv("THOTH_REQUEST_RATE")
+	maxInFlightString = os.Getenv("THOTH_MAX_IN_FLIGHT")
+	maxCollectString  = os.Getenv("THOTH_MAX_COLLECTING")
+	loadProfileString = os.Getenv("THOTH_LOAD_PROFILE")
+	lateWindowString  = os.Getenv("THOTH_LATE_ENVELOPE_WINDOW")
+	maxPendingString  = os.Getenv("THOTH_MAX_PENDING")
+	archivePath       = os.Getenv("THOTH_ARCHIVE_PATH")
+	archiveMaxBytes   = os.Getenv("THOTH_ARCHIVE_MAX_BYTES")
+	archiveMaxAge     = os.Getenv("THOTH_ARCHIVE_MAX_AGE")
+	archiveGzip       = os.Getenv("THOTH_ARCHIVE_GZIP") == "true"
 
-	dogURL = "https://app.datadoghq.com/api/v1/series?api_key=" + os.Getenv("DATADOG_API_KEY")
+	aggregationWindow    = os.Getenv("THOTH_AGGREGATION_WINDOW")
+	aggregationPerSample = os.Getenv("THOTH_AGGREGATION_PER_SAMPLE") == "true"
+	aggregationTags      = os.Getenv("THOTH_AGGREGATION_TAGS")
 
-	logger     lager.Logger
-	threads    int
-	tokenMutex = sync.Mutex{}
-	token      string
+	datadogBatchSize     = os.Getenv("THOTH_DATADOG_BATCH_SIZE")
+	datadogFlushInterval = os.Getenv("THOTH_DATADOG_FLUSH_INTERVAL")
+	datadogMaxRetries    = os.Getenv("THOTH_DATADOG_MAX_RETRIES")
+	datadogSpillDir      = os.Getenv("THOTH_DATADOG_SPILL_DIR")
+	datadogMaxSpillBytes = os.Getenv("THOTH_DATADOG_MAX_SPILL_BYTES")
+	datadogMetricType    = os.Getenv("THOTH_DATADOG_METRIC_TYPE")
+	datadogUnit          = os.Getenv("THOTH_DATADOG_UNIT")
 
-	appGuid, appUrl, dopplerAddress string
-	cfAssistant                     *assistant.Assistant
+	datadogAPIKey = os.Getenv("DATADOG_API_KEY")
+	datadogAppKey = os.Getenv("DATADOG_APP_KEY")
+
+	logger      lager.Logger
+	threads     int
+	requestRate float64
+	maxInFlight int
+	loadProfile benchmark.LoadProfile
+	token       string
+
+	// cfMutex serializes cf CLI commands, which share CF_HOME, and guards token.
+	cfMutex = sync.Mutex{}
+
+	dopplerAddress string
+	targets        []*benchmark.Target
+	cfAssistant    *assistant.Assistant
+	metricSink     metrics.MetricSink
+	prometheusSink *metrics.PrometheusSink
 )
 
 type Clock struct {
@@ -60,40 +103,121 @@ func (fc *Clock) Since(t time.Time) time.Duration {
 	return time.Since(t)
 }
 
+func (fc *Clock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
 func main() {
 	cf_lager.AddFlags(flag.CommandLine)
 	flag.Parse()
 	logger, _ = cf_lager.New("thoth")
 
-	threads, err := strconv.Atoi(threadsString)
+	threads = parseInt("THOTH_THREADS", threadsString, 1)
+	requestRate = parseFloat("THOTH_REQUEST_RATE", requestRateString, 0)
+	maxInFlight = parsePositiveInt("THOTH_MAX_IN_FLIGHT", maxInFlightString, 100)
+	maxCollecting := parsePositiveInt("THOTH_MAX_COLLECTING", maxCollectString, 100)
+	maxPending := parseInt("THOTH_MAX_PENDING", maxPendingString, 1000)
+	lateWindow := parseDuration("THOTH_LATE_ENVELOPE_WINDOW", lateWindowString, time.Minute)
+	logger.Info("starting", lager.Data{"threads": threads})
+	if (requestRate > 0 || loadProfileString != "") && threads > 1 {
+		logger.Info("ignoring-threads", lager.Data{"rate": requestRate, "load-profile": loadProfileString, "threads": threads})
+	}
+
+	var err error
+	requestShape, err := newRequestShape()
 	if err != nil {
-		threads = 1
+		logger.Fatal("request-shape", err)
+	}
+	sizes, err := benchmark.ParseResponseSizes(responseSizes)
+	if err != nil {
+		logger.Fatal("response-sizes", err)
+	}
+
+	if loadProfileString != "" {
+		loadProfile, err = benchmark.ParseLoadProfile(loadProfileString)
+		if err != nil {
+			logger.Fatal("load-profile", err)
+		}
+		if requestRate > 0 {
+			logger.Fatal("load-profile", errors.New("THOTH_LOAD_PROFILE and THOTH_REQUEST_RATE cannot be combined"))
+		}
+	}
+
+	metricSink, err = newMetricSink(sinksString)
+	if err != nil {
+		logger.Fatal("metric-sinks", err)
 	}
-	logger.Info("starting", lager.Data{"threads": threads})
 
 	apiUrl := "api." + systemDomain
 	cfAssistant = assistant.NewAssistant(apiUrl, username, password, org, space, skipSSLValidation)
 	cfAssistant.GetOauthToken()
 
-	appGuid, err = cfAssistant.AppGuid(appName)
+	if targetsString == "" {
+		targetsString = appName
+	}
+	targets, err = benchmark.ParseTargets(targetsString)
 	if err != nil {
-		logger.Fatal("app-guid", err)
+		logger.Fatal("targets", err)
+	}
+	if len(targets) == 0 {
+		logger.Fatal("targets", errors.New("No app to benchmark, set CF_APP_NAME or THOTH_TARGETS."))
 	}
+	for _, target := range targets {
+		target.Guid, err = cfAssistant.AppGuid(target.App)
+		if err != nil {
+			logger.Fatal("app-guid", err, lager.Data{"app": target.App})
+		}
 
-	hostname := cfAssistant.AppUrl(appName)
-	if hostname == "" {
-		logger.Fatal("app-url", errors.New("Could not find app hostname."))
+		if len(target.Routes) == 0 {
+			target.Routes, err = cfAssistant.AppUrls(target.App)
+			if err != nil {
+				logger.Fatal("app-url", err, lager.Data{"app": target.App})
+			}
+		}
+		if len(target.Routes) == 0 {
+			logger.Fatal("app-url", errors.New("Could not find app hostname."), lager.Data{"app": target.App})
+		}
+		if len(sizes) > 0 {
+			for _, route := range target.Routes {
+				if benchmark.RouteHasPath(route) {
+					logger.Fatal("app-url", errors.New("THOTH_RESPONSE_SIZES cannot be used with routes that have a path"), lager.Data{"app": target.App, "route": route})
+				}
+			}
+		}
+		logger.Info("target", lager.Data{"app": target.App, "guid": target.Guid, "routes": target.Routes})
+	}
+
+	if targetInstances {
+		refreshInstances()
+		go func() {
+			for range time.Tick(time.Minute) {
+				refreshInstances()
+			}
+		}()
 	}
 
-	appUrl = "http://" + hostname
 	dopplerAddress = "wss://doppler." + systemDomain + ":4443"
 	refreshToken(cfAssistant)
 
 	members := grouper.Members{}
-	for i := 0; i < threads; i++ {
-		member := grouper.Member{"measure-" + strconv.Itoa(i), &measurer{i}}
-		members = append(members, member)
+	for _, target := range targets {
+		hub := benchmark.NewHub(NewClock(), maxPending, lateWindow, reportDelivery(target))
+		members = append(members, grouper.Member{Name: "firehose-" + target.App, Runner: &firehose{target: target, hub: hub}})
+		collecting := make(chan struct{}, maxCollecting)
+		shapes := benchmark.NewSizeSweep(requestShape, sizes)
+		for i := 0; i < measurersPerTarget(); i++ {
+			member := grouper.Member{Name: "measure-" + target.App + "-" + strconv.Itoa(i), Runner: &measurer{index: i, target: target, hub: hub, sink: metricSink, shapes: shapes, collecting: collecting}}
+			members = append(members, member)
+		}
+	}
+	if port == "" {
+		port = "8080"
+	}
+	mux := http.NewServeMux()
+	if prometheusSink != nil {
+		mux.Handle("/metrics", prometheusSink)
 	}
+	members = append(members, grouper.Member{Name: "metrics-server", Runner: http_server.New(":"+port, mux)})
 	group := grouper.NewParallel(os.Interrupt, members)
 
 	monitor := ifrit.Invoke(sigmon.New(group))
@@ -101,6 +225,14 @@ func main() {
 	logger.Info("started")
 
 	err = <-monitor.Wait()
+
+	if flushErr := metricSink.Flush(); flushErr != nil {
+		logger.Error("flushing-metrics-failed", flushErr)
+	}
+	if closeErr := metricSink.Close(); closeErr != nil {
+		logger.Error("closing-metrics-failed", closeErr)
+	}
+
 	if err != nil {
 		logger.Error("exited-with-failure", err)
 		os.Exit(1)
@@ -109,87 +241,445 @@ func main() {
 	logger.Info("exited")
 }
 
-type measurer struct {
-	index int
+func newRequestShape() (benchmark.RequestShape, error) {
+	headers, err := benchmark.ParseHeaders(requestHeaders)
+	if err != nil {
+		return benchmark.RequestShape{}, err
+	}
+
+	var body []byte
+	if requestBody != "" {
+		body = []byte(requestBody)
+	}
+	return benchmark.NewRequestShape(requestMethod, headers, body, parseInt("THOTH_REQUEST_BODY_SIZE", requestBodySize, 0), requestPath)
 }
 
-func (m *measurer) Run(signals <-chan os.Signal, ready chan<- struct{}) error {
-	log := logger.Session("measurer-" + strconv.Itoa(m.index))
+func newMetricSink(names string) (metrics.MetricSink, error) {
+	if names == "" {
+		names = "datadog"
+	}
+	window := parseDuration("THOTH_AGGREGATION_WINDOW", aggregationWindow, 0)
+	aggregating := window > 0
+	if aggregating && !aggregationPerSample && datadogMetricType == "distribution" {
+		return nil, errors.New("datadog distributions need every sample: set THOTH_AGGREGATION_PER_SAMPLE or unset THOTH_AGGREGATION_WINDOW")
+	}
+
+	sinks := []metrics.MetricSink{}
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		switch name {
+		case "datadog":
+			datadogSink, err := newDatadogSink(aggregating)
+			if err != nil {
+				return nil, err
+			}
+			sinks = append(sinks, datadogSink)
+		case "prometheus":
+			prometheusSink = metrics.NewPrometheusSink(metrics.DefaultLatencyBuckets)
+			sinks = append(sinks, prometheusSink)
+		case "statsd", "dogstatsd":
+			if statsdAddress == "" {
+				statsdAddress = "127.0.0.1:8125"
+			}
+			statsdSink, err := metrics.NewStatsdSink(statsdAddress, name == "dogstatsd", logger)
+			if err != nil {
+				return nil, err
+			}
+			sinks = append(sinks, statsdSink)
+		case "influx":
+			if influxDestination == "" {
+				return nil, errors.New("the influx sink requires THOTH_INFLUX_DESTINATION to be set")
+			}
+			influxSink, err := metrics.NewInfluxSink(influxDestination, logger)
+			if err != nil {
+				return nil, err
+			}
+			sinks = append(sinks, influxSink)
+		case "archive":
+			archiveSink, err := newArchiveSink()
+			if err != nil {
+				return nil, err
+			}
+			sinks = append(sinks, archiveSink)
+		default:
+			return nil, errors.New("unknown metric sink: " + name)
+		}
+	}
+
+	if aggregating {
+		keyTags := metrics.DefaultAggregationTags
+		if aggregationTags != "" {
+			keyTags = []string{}
+			for _, name := range strings.Split(aggregationTags, ",") {
+				keyTags = append(keyTags, strings.TrimSpace(name))
+			}
+		}
+		return metrics.NewAggregatingSink(sinks, window, aggregationPerSample, keyTags, logger), nil
+	}
+	return metrics.NewMultiSink(sinks...), nil
+}
+
+func newDatadogSink(summaries bool) (*metrics.DatadogSink, error) {
+	if datadogUnit == "" {
+		datadogUnit = "nanosecond"
+	}
+	unit, err := metrics.ParseDatadogUnit(datadogUnit)
+	if err != nil {
+		return nil, err
+	}
+
+	sink := metrics.NewDatadogSink(metrics.DatadogSinkConfig{
+		APIKey:        datadogAPIKey,
+		AppKey:        datadogAppKey,
+		Encoder:       metrics.DatadogEncoder{Distribution: datadogMetricType == "distribution", Summaries: summaries, Unit: unit},
+		BatchSize:     parseInt("THOTH_DATADOG_BATCH_SIZE", datadogBatchSize, metrics.DEFAULT_DATADOG_BATCH_SIZE),
+		FlushInterval: parseDuration("THOTH_DATADOG_FLUSH_INTERVAL", datadogFlushInterval, metrics.DEFAULT_DATADOG_FLUSH_INTERVAL),
+		MaxRetries:    parseInt("THOTH_DATADOG_MAX_RETRIES", datadogMaxRetries, metrics.DEFAULT_DATADOG_MAX_RETRIES),
+		RetryBackoff:  metrics.DEFAULT_DATADOG_RETRY_BACKOFF,
+		SpillDir:      datadogSpillDir,
+		MaxSpillBytes: int64(parseInt("THOTH_DATADOG_MAX_SPILL_BYTES", datadogMaxSpillBytes, metrics.DEFAULT_DATADOG_MAX_SPILL_BYTES)),
+	}, logger)
+
+	if datadogAppKey != "" {
+		if err := sink.SubmitMetadata(); err != nil {
+			logger.Error("datadog-metadata-failed", err)
+		}
+	}
+	return sink, nil
+}
+
+func newArchiveSink() (*metrics.ArchiveSink, error) {
+	if archivePath == "" {
+		archivePath = "thoth-results.jsonl"
+	}
+
+	var maxBytes int64
+	if archiveMaxBytes != "" {
+		var err error
+		maxBytes, err = strconv.ParseInt(archiveMaxBytes, 10, 64)
+		if err != nil {
+			return nil, errors.New("invalid THOTH_ARCHIVE_MAX_BYTES: " + err.Error())
+		}
+	}
+
+	var maxAge time.Duration
+	if archiveMaxAge != "" {
+		var err error
+		maxAge, err = time.ParseDuration(archiveMaxAge)
+		if err != nil {
+			return nil, errors.New("invalid THOTH_ARCHIVE_MAX_AGE: " + err.Error())
+		}
+	}
+
+	file, err := metrics.NewRotatingFile(archivePath, maxBytes, maxAge, archiveGzip)
+	if err != nil {
+		return nil, err
+	}
+	return metrics.NewArchiveSink(file), nil
+}
+
+// parseInt, parseFloat and parseDuration return defaultValue for unset
+// variables and stop thoth on malformed ones rather than ignore them.
+func parseInt(name, value string, defaultValue int) int {
+	if value == "" {
+		return defaultValue
+	}
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		logger.Fatal("invalid-configuration", err, lager.Data{"variable": name, "value": value})
+	}
+	return i
+}
+
+func parsePositiveInt(name, value string, defaultValue int) int {
+	i := parseInt(name, value, defaultValue)
+	if i <= 0 {
+		logger.Fatal("invalid-configuration", errors.New("must be greater than zero"), lager.Data{"variable": name, "value": value})
+	}
+	return i
+}
+
+func parseFloat(name, value string, defaultValue float64) float64 {
+	if value == "" {
+		return defaultValue
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		logger.Fatal("invalid-configuration", err, lager.Data{"variable": name, "value": value})
+	}
+	return f
+}
+
+func parseDuration(name, value string, defaultValue time.Duration) time.Duration {
+	if value == "" {
+		return defaultValue
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		logger.Fatal("invalid-configuration", err, lager.Data{"variable": name, "value": value})
+	}
+	return d
+}
+
+type firehose struct {
+	target *benchmark.Target
+	hub    *benchmark.Hub
+}
+
+func (f *firehose) Run(signals <-chan os.Signal, ready chan<- struct{}) error {
+	log := logger.Session("firehose", lager.Data{"app": f.target.App})
 
 	log.Info("streaming-logs")
-	channel, errorChan := connectToFirehose(cfAssistant, dopplerAddress, appGuid)
+	channel, errorChan := connectToFirehose(cfAssistant, dopplerAddress, f.target.Guid)
 	close(ready)
 	log.Info("ready")
 
-	clock := NewClock()
-	ticker := time.Tick(5 * time.Second)
+	stats := time.NewTicker(time.Minute)
+	defer stats.Stop()
+	expire := time.NewTicker(time.Second)
+	defer expire.Stop()
 	for {
 		select {
-		case <-ticker:
-			log.Info("tick")
-			br, err := benchmark.NewBenchmarkRequest(appUrl, channel, clock, 2*time.Second)
-			if err != nil {
-				log.Error("benchmark-request-creation-failed", err)
-				continue
-			}
-			response, err := br.Do()
-			if err != nil {
-				log.Error("benchmark-request-failed", err)
+		case envelope, ok := <-channel:
+			if !ok {
+				log.Info("reconnecting")
+				refreshToken(cfAssistant)
+				channel, errorChan = connectToFirehose(cfAssistant, dopplerAddress, f.target.Guid)
 				continue
 			}
-
-			log.Info("benchmark", lager.Data{
-				"response-code:":   response.ResponseCode,
-				"total-roundtrip":  response.TotalRoundrip,
-				"time-in-app":      response.TimeInApp,
-				"time-in-gorouter": response.TimeInRouter,
-				"rest-of-time":     response.RestOfTime,
-			})
-
-			m.emitMetric(response.ToDatadog(deploymentName, m.index))
+			f.hub.Dispatch(envelope)
 		case err := <-errorChan:
 			if err != nil {
 				log.Error("firehose-disconnect", err)
 			} else {
 				refreshToken(cfAssistant)
-				channel, errorChan = connectToFirehose(cfAssistant, dopplerAddress, appGuid)
+				channel, errorChan = connectToFirehose(cfAssistant, dopplerAddress, f.target.Guid)
 			}
-			continue
+		case <-expire.C:
+			f.hub.Expire()
+		case <-stats.C:
+			hubStats := f.hub.Stats()
+			log.Info("envelopes", lager.Data{"matched": hubStats.Matched, "unmatched": hubStats.Unmatched, "dropped": hubStats.Dropped, "late": hubStats.Late, "lost": hubStats.Lost})
 		case s := <-signals:
-			log.Error("closing", nil, lager.Data{"signal": s})
+			log.Info("closing", lager.Data{"signal": s})
 			return nil
 		}
 	}
+}
+
+// measurersPerTarget is THOTH_THREADS, except in open-loop and load profile
+// mode where a single scheduler or driver per target sets the load.
+func measurersPerTarget() int {
+	if requestRate > 0 || loadProfile != nil {
+		return 1
+	}
+	return threads
+}
+
+type measurer struct {
+	index  int
+	target *benchmark.Target
+	hub    *benchmark.Hub
+	sink   metrics.MetricSink
+
+	// shapes is shared by the measurers of a target, so each target sweeps
+	// through every response size on its own.
+	shapes *benchmark.SizeSweep
+
+	// collecting is shared by the measurers of a target and bounds the requests
+	// waiting for their envelopes; requests beyond it skip the collection so
+	// that firehose lag never holds up sending.
+	collecting chan struct{}
+
+	collectors sync.WaitGroup
+}
+
+func (m *measurer) Run(signals <-chan os.Signal, ready chan<- struct{}) error {
+	log := logger.Session("measurer-"+strconv.Itoa(m.index), lager.Data{"app": m.target.App})
+	close(ready)
+	log.Info("ready")
+
+	clock := NewClock()
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if loadProfile != nil {
+			log.Info("load-profile", lager.Data{"profile": loadProfileString})
+			driver := benchmark.NewLoadDriver(loadProfile, clock, time.Second)
+			driver.Run(stop, func(stage benchmark.Stage) error {
+				return m.measure(log, clock, time.Time{}, stage.Tags()...)
+			})
+		} else if requestRate > 0 {
+			log.Info("open-loop", lager.Data{"rate": requestRate, "max-in-flight": maxInFlight})
+			scheduler := benchmark.NewScheduler(requestRate, maxInFlight, clock)
+			scheduler.Run(stop, func(scheduledAt time.Time) {
+				m.measure(log, clock, scheduledAt)
+			})
+		} else {
+			ticker := time.NewTicker(5 * time.Second)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					log.Info("tick")
+					m.measure(log, clock, time.Time{})
+				case <-stop:
+					return
+				}
+			}
+		}
+	}()
+
+	s := <-signals
+	log.Error("closing", nil, lager.Data{"signal": s})
+	close(stop)
+	<-done
+	m.collectors.Wait()
 	return nil
 }
 
-func (m *measurer) emitMetric(req interface{}) {
-	log := logger.Session("datadog-" + strconv.Itoa(m.index))
-	buf, err := json.Marshal(req)
+func (m *measurer) measure(log lager.Logger, clock benchmark.Clock, scheduledAt time.Time, extraTags ...string) error {
+	route := m.target.NextRoute()
+	tags := append(append(m.tags(), m.target.RouteTags(route)...), extraTags...)
+
+	shape := m.shapes.Next()
+	if instance, ok := m.target.NextInstance(); targetInstances && ok {
+		shape = shape.WithHeader("X-CF-APP-INSTANCE", m.target.InstanceHeader(instance))
+		tags = append(tags, "target_instance:"+strconv.Itoa(instance))
+	}
+
+	channel := m.hub.Subscribe()
+	br, err := benchmark.NewBenchmarkRequest(m.target.URL(route), shape, channel, clock, 2*time.Second)
 	if err != nil {
-		log.Error("cannot-marshal-metric", err)
-		return
+		m.hub.Unsubscribe(channel)
+		log.Error("benchmark-request-creation-failed", err)
+		m.emitFailure(log, err, tags)
+		return err
+	}
+	m.hub.Route(channel, br.Guid.String())
+	br.ScheduledAt = scheduledAt
+	if err := br.Send(); err != nil {
+		m.hub.Unsubscribe(channel)
+		log.Error("benchmark-request-failed", err)
+		m.emitFailure(log, err, tags)
+		return err
 	}
-	resp, err := http.Post(dogURL, "application/json", bytes.NewReader(buf))
+	if requestId := br.RequestId(); requestId != "" {
+		m.hub.Route(channel, requestId)
+	}
+
+	select {
+	case m.collecting <- struct{}{}:
+	default:
+		m.hub.Unsubscribe(channel)
+		log.Info("collection-skipped", lager.Data{"guid": br.Guid.String(), "max-collecting": cap(m.collecting)})
+		return nil
+	}
+
+	m.collectors.Add(1)
+	go func() {
+		defer m.collectors.Done()
+		defer func() { <-m.collecting }()
+		err := m.collect(log, br, tags)
+		if missing := benchmark.MissingEnvelopes(err); len(missing) > 0 {
+			m.hub.Abandon(channel, br.Guid.String(), br.CompletedAt(), missing, tags)
+		} else {
+			m.hub.Complete(channel, br.Guid.String(), br.CompletedAt(), tags)
+		}
+	}()
+	return nil
+}
+
+func (m *measurer) collect(log lager.Logger, br *benchmark.BenchmarkRequest, tags []string) error {
+	response, err := br.Collect()
 	if err != nil {
-		log.Error("cannot-emit-metric", err)
-		return
+		log.Error("benchmark-request-failed", err)
+		m.emitFailure(log, err, tags)
+		return err
 	}
-	respBody, _ := ioutil.ReadAll(resp.Body)
-	log.Info("metric-emitted", lager.Data{
-		"response-code": resp.StatusCode,
-		"body":          respBody,
+
+	log.Info("benchmark", lager.Data{
+		"response-code:":      response.ResponseCode,
+		"total-roundtrip":     response.TotalRoundrip,
+		"corrected-roundtrip": response.CorrectedRoundtrip(),
+		"time-in-app":         response.TimeInApp,
+		"time-in-gorouter":    response.TimeInRouter,
+		"rest-of-time":        response.RestOfTime,
+		"body-bytes":          response.BodyBytes,
+		"router-status":       response.AccessLog.StatusCode,
+		"router-bytes-sent":   response.AccessLog.BytesSent,
+		"instance-id":         response.InstanceId,
 	})
+
+	err = m.sink.Emit(response, tags)
+	if err != nil {
+		log.Error("emitting-metric-failed", err)
+	}
+	return nil
+}
+
+func (m *measurer) emitFailure(log lager.Logger, err error, tags []string) {
+	if emitErr := m.sink.EmitFailure(err, tags); emitErr != nil {
+		log.Error("emitting-failure-failed", emitErr)
+	}
+}
+
+func reportDelivery(target *benchmark.Target) benchmark.DeliveryReporter {
+	log := logger.Session("envelopes", lager.Data{"app": target.App})
+	return func(delivery benchmark.EnvelopeDelivery, tags []string) {
+		data := lager.Data{
+			"guid":       delivery.Guid,
+			"event-type": benchmark.EventTypeName(delivery.EventType),
+			"gap":        delivery.Gap,
+		}
+		if delivery.Status == benchmark.DeliveryOnTime {
+			log.Debug("envelope-on-time", data)
+		} else {
+			log.Info("envelope-"+string(delivery.Status), data)
+		}
+
+		deliverySink, ok := metricSink.(metrics.DeliverySink)
+		if !ok {
+			return
+		}
+		if err := deliverySink.EmitDelivery(delivery, tags); err != nil {
+			log.Error("emitting-delivery-failed", err)
+		}
+	}
+}
+
+func (m *measurer) tags() []string {
+	return []string{
+		"deployment:" + deploymentName,
+		"index:" + strconv.Itoa(m.index),
+	}
+}
+
+func refreshInstances() {
+	cfMutex.Lock()
+	defer cfMutex.Unlock()
+	for _, target := range targets {
+		instances, err := cfAssistant.AppInstances(target.Guid)
+		if err != nil {
+			logger.Error("app-instances", err, lager.Data{"app": target.App})
+			continue
+		}
+		target.SetInstances(instances)
+	}
 }
 
 func connectToFirehose(cfAssistant *assistant.Assistant, dopplerAddress, appGuid string) (<-chan *events.Envelope, chan error) {
 	errorChan := make(chan error)
-	channel := assistant.StreamRouterLogs(dopplerAddress, token, appGuid, errorChan)
+	cfMutex.Lock()
+	currentToken := token
+	cfMutex.Unlock()
+	channel := assistant.StreamRouterLogs(dopplerAddress, currentToken, appGuid, errorChan)
 	return channel, errorChan
 }
 
 func refreshToken(cfAssistant *assistant.Assistant) {
-	tokenMutex.Lock()
-	defer tokenMutex.Unlock()
+	cfMutex.Lock()
+	defer cfMutex.Unlock()
 	token = cfAssistant.GetOauthToken()
 }
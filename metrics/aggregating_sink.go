@@ -0,0 +1,269 @@
+package metrics
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudfoundry-incubator/thoth/benchmark"
+	"github.com/cloudfoundry-incubator/thoth/hdrhistogram"
+	"github.com/pivotal-golang/lager"
+)
+
+const (
+	HISTOGRAM_HIGHEST_TRACKABLE_MICROSECONDS = int64(time.Hour / time.Microsecond)
+	HISTOGRAM_SIGNIFICANT_FIGURES            = 3
+)
+
+var DefaultAggregationTags = []string{"deployment", "app", "route", "size", "stage", "outcome"}
+
+// phaseWindow keeps negative samples, which clock skew produces for the
+// derived phases, in a histogram of their magnitudes next to the positive
+// ones, so neither side loses precision. The negative histogram is only
+// allocated once a negative sample arrives.
+type phaseWindow struct {
+	positive *hdrhistogram.Histogram
+	negative *hdrhistogram.Histogram
+	min, max time.Duration
+	sum      time.Duration
+}
+
+func newHistogram() *hdrhistogram.Histogram {
+	histogram, _ := hdrhistogram.New(HISTOGRAM_HIGHEST_TRACKABLE_MICROSECONDS, HISTOGRAM_SIGNIFICANT_FIGURES)
+	return histogram
+}
+
+func newPhaseWindow() *phaseWindow {
+	return &phaseWindow{positive: newHistogram()}
+}
+
+func (pw *phaseWindow) record(d time.Duration) error {
+	first := pw.count() == 0
+
+	var err error
+	if d < 0 {
+		if pw.negative == nil {
+			pw.negative = newHistogram()
+		}
+		err = pw.negative.RecordValue(int64(-d / time.Microsecond))
+	} else {
+		err = pw.positive.RecordValue(int64(d / time.Microsecond))
+	}
+	if err != nil {
+		return err
+	}
+
+	if first || d < pw.min {
+		pw.min = d
+	}
+	if first || d > pw.max {
+		pw.max = d
+	}
+	pw.sum += d
+	return nil
+}
+
+func (pw *phaseWindow) negatives() int64 {
+	if pw.negative == nil {
+		return 0
+	}
+	return pw.negative.TotalCount()
+}
+
+func (pw *phaseWindow) count() int64 {
+	return pw.positive.TotalCount() + pw.negatives()
+}
+
+func (pw *phaseWindow) quantile(q float64) time.Duration {
+	negatives := pw.negatives()
+	rank := int64(q/100*float64(pw.count()) + 0.5)
+	if rank < 1 {
+		rank = 1
+	}
+
+	if rank <= negatives {
+		magnitudeRank := negatives - rank + 1
+		return -time.Duration(pw.negative.ValueAtQuantile(float64(magnitudeRank)/float64(negatives)*100)) * time.Microsecond
+	}
+	positives := pw.positive.TotalCount()
+	return time.Duration(pw.positive.ValueAtQuantile(float64(rank-negatives)/float64(positives)*100)) * time.Microsecond
+}
+
+func (pw *phaseWindow) summary() PhaseSummary {
+	count := pw.count()
+	quantile := pw.quantile
+	return PhaseSummary{
+		Count: count,
+		Min:   pw.min,
+		Max:   pw.max,
+		Mean:  pw.sum / time.Duration(count),
+		P50:   quantile(50),
+		P90:   quantile(90),
+		P99:   quantile(99),
+		P999:  quantile(99.9),
+	}
+}
+
+type tagWindow struct {
+	tags   []string
+	phases map[string]*phaseWindow
+}
+
+// AggregatingSink collects samples into HDR histograms per set of key tags
+// and emits a Summary to every SummarySink once per window. Only the tags
+// named in keyTags split windows, so high-cardinality tags such as the router
+// or instance index neither multiply the histograms nor split percentiles.
+// Sinks that cannot take summaries keep receiving every sample, as does
+// everything when perSample is set.
+type AggregatingSink struct {
+	sinks     []MetricSink
+	window    time.Duration
+	perSample bool
+	keyTags   map[string]bool
+	logger    lager.Logger
+
+	mutex   sync.Mutex
+	windows map[string]*tagWindow
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func NewAggregatingSink(sinks []MetricSink, window time.Duration, perSample bool, keyTags []string, logger lager.Logger) *AggregatingSink {
+	keys := map[string]bool{}
+	for _, name := range keyTags {
+		keys[name] = true
+	}
+	as := &AggregatingSink{
+		sinks:     sinks,
+		window:    window,
+		perSample: perSample,
+		keyTags:   keys,
+		logger:    logger.Session("aggregator"),
+		windows:   map[string]*tagWindow{},
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	go as.run()
+	return as
+}
+
+func (as *AggregatingSink) Emit(response benchmark.BenchmarkResponse, tags []string) error {
+	as.record(response, append(response.Tags(), tags...))
+
+	return as.each(func(s MetricSink) error {
+		if _, ok := s.(SummarySink); ok && !as.perSample {
+			return nil
+		}
+		return s.Emit(response, tags)
+	})
+}
+
+func (as *AggregatingSink) EmitFailure(err error, tags []string) error {
+	return as.each(func(s MetricSink) error {
+		return s.EmitFailure(err, tags)
+	})
+}
+
+func (as *AggregatingSink) EmitDelivery(delivery benchmark.EnvelopeDelivery, tags []string) error {
+	return NewMultiSink(as.sinks...).EmitDelivery(delivery, tags)
+}
+
+func (as *AggregatingSink) Flush() error {
+	as.emitWindow(time.Now())
+	return as.each(func(s MetricSink) error {
+		return s.Flush()
+	})
+}
+
+func (as *AggregatingSink) Close() error {
+	close(as.stop)
+	<-as.done
+	as.emitWindow(time.Now())
+	return as.each(func(s MetricSink) error {
+		return s.Close()
+	})
+}
+
+func (as *AggregatingSink) run() {
+	defer close(as.done)
+	ticker := time.NewTicker(as.window)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			as.emitWindow(now)
+		case <-as.stop:
+			return
+		}
+	}
+}
+
+func (as *AggregatingSink) record(response benchmark.BenchmarkResponse, allTags []string) {
+	tags := []string{}
+	for _, tag := range allTags {
+		if as.keyTags[strings.SplitN(tag, ":", 2)[0]] {
+			tags = append(tags, tag)
+		}
+	}
+	sorted := append([]string{}, tags...)
+	sort.Strings(sorted)
+	key := strings.Join(sorted, ",")
+
+	as.mutex.Lock()
+	defer as.mutex.Unlock()
+
+	w, ok := as.windows[key]
+	if !ok {
+		w = &tagWindow{tags: tags, phases: map[string]*phaseWindow{}}
+		as.windows[key] = w
+	}
+
+	for _, phase := range response.Phases() {
+		pw, ok := w.phases[phase.Name]
+		if !ok {
+			pw = newPhaseWindow()
+			w.phases[phase.Name] = pw
+		}
+		if err := pw.record(phase.Duration); err != nil {
+			as.logger.Error("recording-sample-failed", err, lager.Data{"phase": phase.Name, "duration": phase.Duration.String()})
+		}
+	}
+}
+
+func (as *AggregatingSink) emitWindow(now time.Time) {
+	as.mutex.Lock()
+	windows := as.windows
+	as.windows = map[string]*tagWindow{}
+	as.mutex.Unlock()
+
+	for _, w := range windows {
+		summary := Summary{
+			Timestamp: now,
+			Window:    as.window,
+			Phases:    map[string]PhaseSummary{},
+		}
+		for phase, pw := range w.phases {
+			if pw.count() == 0 {
+				continue
+			}
+			summary.Phases[phase] = pw.summary()
+		}
+
+		for _, s := range as.sinks {
+			summarySink, ok := s.(SummarySink)
+			if !ok {
+				continue
+			}
+			if err := summarySink.EmitSummary(summary, w.tags); err != nil {
+				as.logger.Error("emitting-summary-failed", err)
+			}
+		}
+	}
+}
+
+func (as *AggregatingSink) each(f func(MetricSink) error) error {
+	return NewMultiSink(as.sinks...).each(f)
+}
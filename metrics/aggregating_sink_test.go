@@ -0,0 +1,161 @@
+package metrics_test
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/cloudfoundry-incubator/thoth/benchmark"
+	. "github.com/cloudfoundry-incubator/thoth/metrics"
+	"github.com/pivotal-golang/lager/lagertest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type fakeSummarySink struct {
+	fakeSink
+	summaries   []Summary
+	summaryTags [][]string
+}
+
+func (fs *fakeSummarySink) EmitSummary(summary Summary, tags []string) error {
+	fs.summaries = append(fs.summaries, summary)
+	fs.summaryTags = append(fs.summaryTags, tags)
+	return nil
+}
+
+var _ = Describe("AggregatingSink", func() {
+	var (
+		summarySink *fakeSummarySink
+		plainSink   *fakeSink
+		perSample   bool
+		sink        *AggregatingSink
+	)
+
+	sample := func(roundtrip time.Duration) benchmark.BenchmarkResponse {
+		return benchmark.BenchmarkResponse{
+			ResponseCode:  http.StatusOK,
+			TotalRoundrip: roundtrip,
+			TimeInApp:     roundtrip / 2,
+			TimeInRouter:  roundtrip / 4,
+			RestOfTime:    roundtrip / 4,
+		}
+	}
+
+	BeforeEach(func() {
+		summarySink = &fakeSummarySink{}
+		plainSink = &fakeSink{}
+		perSample = false
+	})
+
+	JustBeforeEach(func() {
+		sink = NewAggregatingSink([]MetricSink{summarySink, plainSink}, time.Hour, perSample, []string{"outcome", "index"}, lagertest.NewTestLogger("test"))
+	})
+
+	AfterEach(func() {
+		sink.Close()
+	})
+
+	It("emits percentiles per phase for each window", func() {
+		for i := 1; i <= 100; i++ {
+			Expect(sink.Emit(sample(time.Duration(i)*time.Millisecond), []string{"index:0"})).To(Succeed())
+		}
+		Expect(sink.Flush()).To(Succeed())
+
+		Expect(summarySink.summaries).To(HaveLen(1))
+		Expect(summarySink.summaryTags[0]).To(Equal([]string{"outcome:success", "index:0"}))
+
+		roundtrip := summarySink.summaries[0].Phases["total_roundtrip"]
+		Expect(roundtrip.Count).To(Equal(int64(100)))
+		Expect(roundtrip.Min).To(Equal(1 * time.Millisecond))
+		Expect(roundtrip.Max).To(Equal(100 * time.Millisecond))
+		Expect(roundtrip.Mean).To(Equal(50500 * time.Microsecond))
+		Expect(roundtrip.P50).To(BeNumerically("~", 50*time.Millisecond, 100*time.Microsecond))
+		Expect(roundtrip.P90).To(BeNumerically("~", 90*time.Millisecond, 100*time.Microsecond))
+		Expect(roundtrip.P99).To(BeNumerically("~", 99*time.Millisecond, 100*time.Microsecond))
+		Expect(roundtrip.P999).To(BeNumerically("~", 100*time.Millisecond, 100*time.Microsecond))
+
+		Expect(summarySink.summaries[0].Phases["time_in_app"].Max).To(Equal(50 * time.Millisecond))
+	})
+
+	It("keeps negative samples instead of clamping them", func() {
+		for i := -50; i < 50; i++ {
+			response := sample(time.Millisecond)
+			response.RestOfTime = time.Duration(i) * time.Millisecond
+			Expect(sink.Emit(response, nil)).To(Succeed())
+		}
+		Expect(sink.Flush()).To(Succeed())
+
+		restOfTime := summarySink.summaries[0].Phases["rest_of_time"]
+		Expect(restOfTime.Count).To(Equal(int64(100)))
+		Expect(restOfTime.Min).To(Equal(-50 * time.Millisecond))
+		Expect(restOfTime.Max).To(Equal(49 * time.Millisecond))
+		Expect(restOfTime.Mean).To(Equal(-500 * time.Microsecond))
+		Expect(restOfTime.P50).To(BeNumerically("~", -1*time.Millisecond, 100*time.Microsecond))
+		Expect(restOfTime.P90).To(BeNumerically("~", 39*time.Millisecond, 100*time.Microsecond))
+		Expect(restOfTime.P999).To(BeNumerically("~", 49*time.Millisecond, 100*time.Microsecond))
+	})
+
+	It("skips samples beyond the trackable range", func() {
+		response := sample(time.Millisecond)
+		response.RestOfTime = 2 * time.Hour
+		Expect(sink.Emit(response, nil)).To(Succeed())
+		Expect(sink.Flush()).To(Succeed())
+
+		Expect(summarySink.summaries[0].Phases).NotTo(HaveKey("rest_of_time"))
+		Expect(summarySink.summaries[0].Phases).To(HaveKey("total_roundtrip"))
+	})
+
+	It("keeps a window per tag set", func() {
+		Expect(sink.Emit(sample(time.Millisecond), []string{"index:0"})).To(Succeed())
+		Expect(sink.Emit(sample(time.Millisecond), []string{"index:1"})).To(Succeed())
+		Expect(sink.Flush()).To(Succeed())
+
+		Expect(summarySink.summaries).To(HaveLen(2))
+	})
+
+	It("only splits windows by the key tags", func() {
+		Expect(sink.Emit(sample(time.Millisecond), []string{"index:0", "router_index:0"})).To(Succeed())
+		Expect(sink.Emit(sample(time.Millisecond), []string{"index:0", "router_index:1"})).To(Succeed())
+		Expect(sink.Flush()).To(Succeed())
+
+		Expect(summarySink.summaries).To(HaveLen(1))
+		Expect(summarySink.summaries[0].Phases["total_roundtrip"].Count).To(Equal(int64(2)))
+		Expect(summarySink.summaryTags[0]).To(Equal([]string{"outcome:success", "index:0"}))
+	})
+
+	It("starts a new window after emitting", func() {
+		Expect(sink.Emit(sample(time.Millisecond), nil)).To(Succeed())
+		Expect(sink.Flush()).To(Succeed())
+		Expect(sink.Flush()).To(Succeed())
+
+		Expect(summarySink.summaries).To(HaveLen(1))
+	})
+
+	It("only sends samples to sinks that cannot take summaries", func() {
+		Expect(sink.Emit(sample(time.Millisecond), nil)).To(Succeed())
+
+		Expect(summarySink.emitted).To(BeEmpty())
+		Expect(plainSink.emitted).To(HaveLen(1))
+	})
+
+	It("passes failures through", func() {
+		Expect(sink.EmitFailure(&benchmark.TransportError{}, nil)).To(Succeed())
+
+		Expect(summarySink.failed).To(HaveLen(1))
+		Expect(plainSink.failed).To(HaveLen(1))
+	})
+
+	Context("when per-sample emission is enabled", func() {
+		BeforeEach(func() {
+			perSample = true
+		})
+
+		It("sends samples to every sink as well", func() {
+			Expect(sink.Emit(sample(time.Millisecond), nil)).To(Succeed())
+
+			Expect(summarySink.emitted).To(HaveLen(1))
+			Expect(plainSink.emitted).To(HaveLen(1))
+		})
+	})
+})
@@ -0,0 +1,121 @@
+package metrics
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/cloudfoundry-incubator/thoth/benchmark"
+)
+
+type ArchiveRecord struct {
+	Timestamp time.Time `json:"timestamp"`
+	Guid      string    `json:"guid,omitempty"`
+	Tags      []string  `json:"tags"`
+
+	ResponseCode   int   `json:"response_code,omitempty"`
+	TotalRoundtrip int64 `json:"total_roundtrip_ns,omitempty"`
+	TimeInRouter   int64 `json:"time_in_gorouter_ns,omitempty"`
+	TimeInApp      int64 `json:"time_in_app_ns,omitempty"`
+	RestOfTime     int64 `json:"rest_of_time_ns,omitempty"`
+
+	DerivedTimeInRouter int64 `json:"derived_time_in_gorouter_ns,omitempty"`
+	DerivedTimeInApp    int64 `json:"derived_time_in_app_ns,omitempty"`
+
+	DNSLookup       int64 `json:"dns_lookup_ns,omitempty"`
+	Connect         int64 `json:"connect_ns,omitempty"`
+	TLSHandshake    int64 `json:"tls_handshake_ns,omitempty"`
+	TimeToFirstByte int64 `json:"time_to_first_byte_ns,omitempty"`
+	BodyRead        int64 `json:"body_read_ns,omitempty"`
+
+	BodyBytes           int64  `json:"body_bytes,omitempty"`
+	RouterStatus        int    `json:"router_status,omitempty"`
+	RouterBytesSent     int64  `json:"router_bytes_sent,omitempty"`
+	RouterBytesReceived int64  `json:"router_bytes_received,omitempty"`
+	VcapRequestId       string `json:"vcap_request_id,omitempty"`
+	RouterIp            string `json:"router_ip,omitempty"`
+	InstanceId          string `json:"instance_id,omitempty"`
+
+	Error string `json:"error,omitempty"`
+}
+
+type ArchiveSink struct {
+	mutex   sync.Mutex
+	writer  io.WriteCloser
+	encoder *json.Encoder
+}
+
+func NewArchiveSink(writer io.WriteCloser) *ArchiveSink {
+	return &ArchiveSink{
+		writer:  writer,
+		encoder: json.NewEncoder(writer),
+	}
+}
+
+func (as *ArchiveSink) Emit(response benchmark.BenchmarkResponse, tags []string) error {
+	return as.write(ArchiveRecord{
+		Timestamp:      response.Timestamp,
+		Guid:           response.Guid.String(),
+		Tags:           append(response.Tags(), tags...),
+		ResponseCode:   response.ResponseCode,
+		TotalRoundtrip: response.TotalRoundrip.Nanoseconds(),
+		TimeInRouter:   response.TimeInRouter.Nanoseconds(),
+		TimeInApp:      response.TimeInApp.Nanoseconds(),
+		RestOfTime:     response.RestOfTime.Nanoseconds(),
+
+		DerivedTimeInRouter: response.DerivedTimeInRouter.Nanoseconds(),
+		DerivedTimeInApp:    response.DerivedTimeInApp.Nanoseconds(),
+
+		DNSLookup:       response.DNSLookup.Nanoseconds(),
+		Connect:         response.Connect.Nanoseconds(),
+		TLSHandshake:    response.TLSHandshake.Nanoseconds(),
+		TimeToFirstByte: response.TimeToFirstByte.Nanoseconds(),
+		BodyRead:        response.BodyRead.Nanoseconds(),
+
+		BodyBytes:           response.BodyBytes,
+		RouterStatus:        response.AccessLog.StatusCode,
+		RouterBytesSent:     response.AccessLog.BytesSent,
+		RouterBytesReceived: response.AccessLog.BytesReceived,
+		VcapRequestId:       response.AccessLog.VcapRequestId,
+		RouterIp:            response.Router.Ip,
+		InstanceId:          response.InstanceId,
+	})
+}
+
+func (as *ArchiveSink) EmitFailure(err error, tags []string) error {
+	record := ArchiveRecord{
+		Timestamp: benchmark.FailureTime(err),
+		Tags:      append(benchmark.FailureTags(err), tags...),
+		Error:     err.Error(),
+	}
+	if requestErr, ok := err.(benchmark.RequestError); ok {
+		timing := requestErr.Timing()
+		record.Guid = timing.Guid.String()
+		record.ResponseCode = timing.ResponseCode
+		record.TotalRoundtrip = timing.TotalRoundtrip.Nanoseconds()
+		record.DNSLookup = timing.DNSLookup.Nanoseconds()
+		record.Connect = timing.Connect.Nanoseconds()
+		record.TLSHandshake = timing.TLSHandshake.Nanoseconds()
+	}
+	return as.write(record)
+}
+
+func (as *ArchiveSink) Flush() error {
+	if syncer, ok := as.writer.(interface {
+		Sync() error
+	}); ok {
+		return syncer.Sync()
+	}
+	return nil
+}
+
+func (as *ArchiveSink) Close() error {
+	return as.writer.Close()
+}
+
+func (as *ArchiveSink) write(record ArchiveRecord) error {
+	as.mutex.Lock()
+	defer as.mutex.Unlock()
+	return as.encoder.Encode(record)
+}
@@ -0,0 +1,86 @@
+package metrics_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/cloudfoundry-incubator/thoth/benchmark"
+	. "github.com/cloudfoundry-incubator/thoth/metrics"
+	"github.com/google/uuid"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type bufferCloser struct {
+	bytes.Buffer
+}
+
+func (bc *bufferCloser) Close() error {
+	return nil
+}
+
+var _ = Describe("ArchiveSink", func() {
+	var (
+		buffer *bufferCloser
+		sink   *ArchiveSink
+		guid   uuid.UUID
+	)
+
+	records := func() []ArchiveRecord {
+		result := []ArchiveRecord{}
+		decoder := json.NewDecoder(&buffer.Buffer)
+		for decoder.More() {
+			var record ArchiveRecord
+			Expect(decoder.Decode(&record)).To(Succeed())
+			result = append(result, record)
+		}
+		return result
+	}
+
+	BeforeEach(func() {
+		buffer = &bufferCloser{}
+		sink = NewArchiveSink(buffer)
+		guid = uuid.New()
+	})
+
+	It("appends a line per response", func() {
+		response := benchmark.BenchmarkResponse{
+			Guid:          guid,
+			ResponseCode:  http.StatusOK,
+			TotalRoundrip: 50 * time.Millisecond,
+			TimeInApp:     20 * time.Millisecond,
+			Timestamp:     time.Unix(123456789, 0).UTC(),
+			InstanceIndex: "1",
+			InstanceId:    "c0ffee",
+		}
+		Expect(sink.Emit(response, []string{"index:0"})).To(Succeed())
+		Expect(sink.Emit(response, []string{"index:1"})).To(Succeed())
+
+		archived := records()
+		Expect(archived).To(HaveLen(2))
+		Expect(archived[0]).To(Equal(ArchiveRecord{
+			Timestamp:      time.Unix(123456789, 0).UTC(),
+			Guid:           guid.String(),
+			Tags:           []string{"status:200", "outcome:success", "instance_index:1", "index:0"},
+			ResponseCode:   http.StatusOK,
+			TotalRoundtrip: int64(50 * time.Millisecond),
+			TimeInApp:      int64(20 * time.Millisecond),
+			InstanceId:     "c0ffee",
+		}))
+	})
+
+	It("records the request guid and error of failed attempts", func() {
+		Expect(sink.EmitFailure(&benchmark.MissingLogMessageError{PartialTiming: benchmark.PartialTiming{Guid: guid, ResponseCode: http.StatusOK, TotalRoundtrip: 50 * time.Millisecond}}, []string{"index:0"})).To(Succeed())
+
+		archived := records()
+		Expect(archived).To(HaveLen(1))
+		Expect(archived[0].Guid).To(Equal(guid.String()))
+		Expect(archived[0].Error).To(Equal("timed out getting LogMessage for request: " + guid.String()))
+		Expect(archived[0].ResponseCode).To(Equal(http.StatusOK))
+		Expect(archived[0].TotalRoundtrip).To(Equal(int64(50 * time.Millisecond)))
+		Expect(archived[0].Tags).To(Equal([]string{"status:200", "outcome:envelope_timeout", "index:0"}))
+	})
+})
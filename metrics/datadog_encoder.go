@@ -0,0 +1,176 @@
+package metrics
+
+import (
+	"errors"
+	"time"
+
+	"github.com/cloudfoundry-incubator/thoth/benchmark"
+)
+
+const (
+	DATADOG_SERIES_ENDPOINT       = "series"
+	DATADOG_DISTRIBUTION_ENDPOINT = "distribution_points"
+)
+
+type DatadogUnit struct {
+	Name string
+	Size time.Duration
+}
+
+var (
+	DatadogNanoseconds  = DatadogUnit{"nanosecond", time.Nanosecond}
+	DatadogMicroseconds = DatadogUnit{"microsecond", time.Microsecond}
+	DatadogMilliseconds = DatadogUnit{"millisecond", time.Millisecond}
+	DatadogSeconds      = DatadogUnit{"second", time.Second}
+)
+
+func ParseDatadogUnit(name string) (DatadogUnit, error) {
+	for _, unit := range []DatadogUnit{DatadogNanoseconds, DatadogMicroseconds, DatadogMilliseconds, DatadogSeconds} {
+		if unit.Name == name {
+			return unit, nil
+		}
+	}
+	return DatadogUnit{}, errors.New("unknown datadog unit: " + name)
+}
+
+type DatadogMetadata struct {
+	Type string `json:"type"`
+	Unit string `json:"unit"`
+}
+
+// DatadogEncoder turns samples into payloads for the Datadog API, keyed by
+// the endpoint they have to be posted to. Phase durations become gauges on
+// the series endpoint, or distributions when Distribution is set so that
+// percentiles are computed by Datadog across every thoth. Summaries is set
+// when windowed summaries are sent as well, so their metrics get metadata.
+type DatadogEncoder struct {
+	Distribution bool
+	Summaries    bool
+	Unit         DatadogUnit
+}
+
+func (de DatadogEncoder) EncodeResponse(response benchmark.BenchmarkResponse, extraTags []string) map[string][]map[string]interface{} {
+	timestamp := response.Timestamp.Unix()
+	tags := append(response.Tags(), extraTags...)
+
+	encoded := map[string][]map[string]interface{}{}
+	for _, phase := range response.Phases() {
+		metric := map[string]interface{}{
+			"metric": "app_benchmarking." + phase.Name,
+			"tags":   tags,
+		}
+		if de.Distribution {
+			metric["points"] = [][]interface{}{{timestamp, []interface{}{de.scale(phase.Duration)}}}
+			encoded[DATADOG_DISTRIBUTION_ENDPOINT] = append(encoded[DATADOG_DISTRIBUTION_ENDPOINT], metric)
+		} else {
+			metric["points"] = [][]interface{}{{timestamp, de.scale(phase.Duration)}}
+			encoded[DATADOG_SERIES_ENDPOINT] = append(encoded[DATADOG_SERIES_ENDPOINT], metric)
+		}
+	}
+
+	encoded[DATADOG_SERIES_ENDPOINT] = append(encoded[DATADOG_SERIES_ENDPOINT], outcomeSeries(response.Timestamp, tags))
+	return encoded
+}
+
+func (de DatadogEncoder) EncodeFailure(err error, tags []string) map[string][]map[string]interface{} {
+	return map[string][]map[string]interface{}{
+		DATADOG_SERIES_ENDPOINT: {
+			outcomeSeries(benchmark.FailureTime(err), append(benchmark.FailureTags(err), tags...)),
+		},
+	}
+}
+
+func (de DatadogEncoder) EncodeDelivery(delivery benchmark.EnvelopeDelivery, tags []string) map[string][]map[string]interface{} {
+	timestamp := delivery.Timestamp.Unix()
+	tags = append(delivery.Tags(), tags...)
+
+	series := []map[string]interface{}{
+		{
+			"metric": "app_benchmarking.envelopes",
+			"type":   "count",
+			"points": [][]interface{}{{timestamp, 1}},
+			"tags":   tags,
+		},
+	}
+
+	encoded := map[string][]map[string]interface{}{}
+	for _, latency := range delivery.Latencies() {
+		metric := map[string]interface{}{
+			"metric": "app_benchmarking.log_delivery_latency",
+			"tags":   append(append([]string{}, tags...), "since:"+latency.Name),
+		}
+		if de.Distribution {
+			metric["points"] = [][]interface{}{{timestamp, []interface{}{de.scale(latency.Duration)}}}
+			encoded[DATADOG_DISTRIBUTION_ENDPOINT] = append(encoded[DATADOG_DISTRIBUTION_ENDPOINT], metric)
+		} else {
+			metric["points"] = [][]interface{}{{timestamp, de.scale(latency.Duration)}}
+			series = append(series, metric)
+		}
+	}
+	encoded[DATADOG_SERIES_ENDPOINT] = series
+	return encoded
+}
+
+func (de DatadogEncoder) EncodeSummary(summary Summary, tags []string) map[string][]map[string]interface{} {
+	timestamp := summary.Timestamp.Unix()
+
+	series := []map[string]interface{}{}
+	for _, phase := range benchmark.PhaseNames {
+		phaseSummary, ok := summary.Phases[phase]
+		if !ok {
+			continue
+		}
+
+		series = append(series, map[string]interface{}{
+			"metric":   "app_benchmarking." + phase + ".count",
+			"type":     "count",
+			"interval": int64(summary.Window.Seconds()),
+			"points":   [][]interface{}{{timestamp, phaseSummary.Count}},
+			"tags":     tags,
+		})
+		for _, stat := range phaseSummary.Stats() {
+			series = append(series, map[string]interface{}{
+				"metric": "app_benchmarking." + phase + "." + stat.Name,
+				"points": [][]interface{}{{timestamp, de.scale(stat.Value)}},
+				"tags":   tags,
+			})
+		}
+	}
+	return map[string][]map[string]interface{}{DATADOG_SERIES_ENDPOINT: series}
+}
+
+func (de DatadogEncoder) Metadata() map[string]DatadogMetadata {
+	metricType := "gauge"
+	if de.Distribution {
+		metricType = "distribution"
+	}
+
+	metadata := map[string]DatadogMetadata{}
+	for _, phase := range benchmark.PhaseNames {
+		metadata["app_benchmarking."+phase] = DatadogMetadata{Type: metricType, Unit: de.Unit.Name}
+		if !de.Summaries {
+			continue
+		}
+		metadata["app_benchmarking."+phase+".count"] = DatadogMetadata{Type: "count", Unit: "request"}
+		for _, stat := range (PhaseSummary{}).Stats() {
+			metadata["app_benchmarking."+phase+"."+stat.Name] = DatadogMetadata{Type: "gauge", Unit: de.Unit.Name}
+		}
+	}
+	return metadata
+}
+
+func (de DatadogEncoder) scale(d time.Duration) interface{} {
+	if de.Unit.Size <= time.Nanosecond {
+		return d.Nanoseconds()
+	}
+	return float64(d) / float64(de.Unit.Size)
+}
+
+func outcomeSeries(timestamp time.Time, tags []string) map[string]interface{} {
+	return map[string]interface{}{
+		"metric": "app_benchmarking.benchmarks",
+		"type":   "count",
+		"points": [][]interface{}{{timestamp.Unix(), 1}},
+		"tags":   tags,
+	}
+}
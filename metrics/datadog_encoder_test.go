@@ -0,0 +1,170 @@
+package metrics_test
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/cloudfoundry-incubator/thoth/benchmark"
+	. "github.com/cloudfoundry-incubator/thoth/metrics"
+	"github.com/cloudfoundry/sonde-go/events"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("DatadogEncoder", func() {
+	var response benchmark.BenchmarkResponse
+
+	BeforeEach(func() {
+		response = benchmark.BenchmarkResponse{
+			ResponseCode:  http.StatusOK,
+			TotalRoundrip: 50 * time.Millisecond,
+			TimeInApp:     20 * time.Millisecond,
+			TimeInRouter:  10 * time.Millisecond,
+			RestOfTime:    20 * time.Millisecond,
+			Timestamp:     time.Unix(123456789, 0),
+		}
+	})
+
+	Context("with nanosecond gauges", func() {
+		encoder := DatadogEncoder{Unit: DatadogNanoseconds}
+
+		It("encodes the phases as series", func() {
+			encoded := encoder.EncodeResponse(response, []string{"index:0"})
+			Expect(encoded).NotTo(HaveKey(DATADOG_DISTRIBUTION_ENDPOINT))
+
+			series := encoded[DATADOG_SERIES_ENDPOINT]
+			Expect(series).To(HaveLen(11))
+			Expect(series[0]).To(Equal(map[string]interface{}{
+				"metric": "app_benchmarking.total_roundtrip",
+				"points": [][]interface{}{{int64(123456789), int64(50 * time.Millisecond)}},
+				"tags":   []string{"status:200", "outcome:success", "index:0"},
+			}))
+			Expect(series[10]["metric"]).To(Equal("app_benchmarking.benchmarks"))
+			Expect(series[10]["type"]).To(Equal("count"))
+		})
+
+		It("describes the phases as gauges", func() {
+			Expect(encoder.Metadata()).To(HaveKeyWithValue("app_benchmarking.time_in_app", DatadogMetadata{Type: "gauge", Unit: "nanosecond"}))
+		})
+	})
+
+	Context("with millisecond distributions", func() {
+		encoder := DatadogEncoder{Distribution: true, Unit: DatadogMilliseconds}
+
+		It("encodes the phases as distribution points", func() {
+			encoded := encoder.EncodeResponse(response, nil)
+			Expect(encoded[DATADOG_SERIES_ENDPOINT]).To(HaveLen(1))
+
+			distributions := encoded[DATADOG_DISTRIBUTION_ENDPOINT]
+			Expect(distributions).To(HaveLen(10))
+			Expect(distributions[2]["metric"]).To(Equal("app_benchmarking.time_in_gorouter"))
+			Expect(distributions[2]["points"]).To(Equal([][]interface{}{{int64(123456789), []interface{}{float64(10)}}}))
+		})
+
+		It("describes the phases as distributions", func() {
+			Expect(encoder.Metadata()).To(HaveLen(12))
+			Expect(encoder.Metadata()).To(HaveKeyWithValue("app_benchmarking.rest_of_time", DatadogMetadata{Type: "distribution", Unit: "millisecond"}))
+		})
+
+		It("describes the summary statistics too when summaries are sent", func() {
+			summaries := DatadogEncoder{Distribution: true, Summaries: true, Unit: DatadogMilliseconds}
+			Expect(summaries.Metadata()).To(HaveLen(12 * 9))
+			Expect(summaries.Metadata()).To(HaveKeyWithValue("app_benchmarking.rest_of_time.count", DatadogMetadata{Type: "count", Unit: "request"}))
+			Expect(summaries.Metadata()).To(HaveKeyWithValue("app_benchmarking.rest_of_time.p99_9", DatadogMetadata{Type: "gauge", Unit: "millisecond"}))
+		})
+	})
+
+	It("encodes failures as outcome counts", func() {
+		failure := &benchmark.TransportError{PartialTiming: benchmark.PartialTiming{Timestamp: time.Unix(123456789, 0)}}
+		encoded := DatadogEncoder{}.EncodeFailure(failure, []string{"index:0"})
+		Expect(encoded[DATADOG_SERIES_ENDPOINT]).To(Equal([]map[string]interface{}{{
+			"metric": "app_benchmarking.benchmarks",
+			"type":   "count",
+			"points": [][]interface{}{{int64(123456789), 1}},
+			"tags":   []string{"outcome:transport_error", "index:0"},
+		}}))
+	})
+
+	It("encodes summaries as a count and a gauge per statistic", func() {
+		summary := Summary{
+			Timestamp: time.Unix(123456789, 0),
+			Window:    time.Minute,
+			Phases: map[string]PhaseSummary{
+				"time_in_app": {Count: 3, P999: 3 * time.Millisecond},
+			},
+		}
+
+		series := DatadogEncoder{Unit: DatadogMilliseconds}.EncodeSummary(summary, nil)[DATADOG_SERIES_ENDPOINT]
+		Expect(series).To(HaveLen(8))
+		Expect(series[0]["metric"]).To(Equal("app_benchmarking.time_in_app.count"))
+		Expect(series[0]["points"]).To(Equal([][]interface{}{{int64(123456789), int64(3)}}))
+		Expect(series[7]["metric"]).To(Equal("app_benchmarking.time_in_app.p99_9"))
+		Expect(series[7]["points"]).To(Equal([][]interface{}{{int64(123456789), float64(3)}}))
+	})
+
+	It("encodes late envelopes as a count and their delivery latency", func() {
+		delivery := benchmark.EnvelopeDelivery{
+			EventType: events.Envelope_LogMessage,
+			Status:    benchmark.DeliveryLate,
+			Timestamp: time.Unix(123456789, 0),
+			Origin:    time.Unix(123456784, 0),
+			Gap:       3 * time.Second,
+		}
+
+		series := DatadogEncoder{Unit: DatadogMilliseconds}.EncodeDelivery(delivery, []string{"index:0"})[DATADOG_SERIES_ENDPOINT]
+		Expect(series).To(Equal([]map[string]interface{}{
+			{
+				"metric": "app_benchmarking.envelopes",
+				"type":   "count",
+				"points": [][]interface{}{{int64(123456789), 1}},
+				"tags":   []string{"delivery:late", "event_type:log_message", "index:0"},
+			},
+			{
+				"metric": "app_benchmarking.log_delivery_latency",
+				"points": [][]interface{}{{int64(123456789), float64(5000)}},
+				"tags":   []string{"delivery:late", "event_type:log_message", "index:0", "since:origin"},
+			},
+			{
+				"metric": "app_benchmarking.log_delivery_latency",
+				"points": [][]interface{}{{int64(123456789), float64(3000)}},
+				"tags":   []string{"delivery:late", "event_type:log_message", "index:0", "since:response"},
+			},
+		}))
+	})
+
+	It("encodes the delivery latency of on time envelopes as distributions", func() {
+		delivery := benchmark.EnvelopeDelivery{
+			EventType: events.Envelope_HttpStartStop,
+			Status:    benchmark.DeliveryOnTime,
+			Timestamp: time.Unix(123456789, 0),
+			Gap:       250 * time.Millisecond,
+		}
+
+		encoded := DatadogEncoder{Distribution: true, Unit: DatadogMilliseconds}.EncodeDelivery(delivery, nil)
+		Expect(encoded[DATADOG_SERIES_ENDPOINT]).To(HaveLen(1))
+		Expect(encoded[DATADOG_DISTRIBUTION_ENDPOINT]).To(Equal([]map[string]interface{}{{
+			"metric": "app_benchmarking.log_delivery_latency",
+			"points": [][]interface{}{{int64(123456789), []interface{}{float64(250)}}},
+			"tags":   []string{"delivery:on_time", "event_type:http_start_stop", "since:response"},
+		}}))
+	})
+
+	It("encodes lost envelopes as a count only", func() {
+		delivery := benchmark.EnvelopeDelivery{EventType: events.Envelope_HttpStartStop, Status: benchmark.DeliveryLost}
+		encoded := DatadogEncoder{}.EncodeDelivery(delivery, nil)
+		Expect(encoded).NotTo(HaveKey(DATADOG_DISTRIBUTION_ENDPOINT))
+		series := encoded[DATADOG_SERIES_ENDPOINT]
+		Expect(series).To(HaveLen(1))
+		Expect(series[0]["tags"]).To(Equal([]string{"delivery:lost", "event_type:http_start_stop"}))
+	})
+
+	It("parses unit names", func() {
+		unit, err := ParseDatadogUnit("microsecond")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(unit).To(Equal(DatadogMicroseconds))
+
+		_, err = ParseDatadogUnit("fortnight")
+		Expect(err).To(HaveOccurred())
+	})
+})
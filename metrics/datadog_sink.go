@@ -0,0 +1,387 @@
+package metrics
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cloudfoundry-incubator/thoth/benchmark"
+	"github.com/pivotal-golang/lager"
+)
+
+const (
+	DEFAULT_DATADOG_API_URL         = "https://app.datadoghq.com/api/v1"
+	DEFAULT_DATADOG_BATCH_SIZE      = 100
+	DEFAULT_DATADOG_FLUSH_INTERVAL  = 10 * time.Second
+	DEFAULT_DATADOG_MAX_RETRIES     = 3
+	DEFAULT_DATADOG_RETRY_BACKOFF   = time.Second
+	DEFAULT_DATADOG_MAX_SPILL_BYTES = 50 * 1024 * 1024
+)
+
+type DatadogSinkConfig struct {
+	APIURL string
+	APIKey string
+	AppKey string
+
+	Encoder DatadogEncoder
+
+	BatchSize     int
+	FlushInterval time.Duration
+	MaxRetries    int
+	RetryBackoff  time.Duration
+
+	SpillDir      string
+	MaxSpillBytes int64
+}
+
+type retryableError struct {
+	err error
+}
+
+func (e retryableError) Error() string {
+	return e.err.Error()
+}
+
+type DatadogSink struct {
+	config DatadogSinkConfig
+	logger lager.Logger
+
+	pendingMutex sync.Mutex
+	pending      map[string][]interface{}
+	pendingCount int
+
+	sendMutex sync.Mutex
+	dropped   uint64
+
+	flushNow chan struct{}
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+func NewDatadogSink(config DatadogSinkConfig, logger lager.Logger) *DatadogSink {
+	if config.APIURL == "" {
+		config.APIURL = DEFAULT_DATADOG_API_URL
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = DEFAULT_DATADOG_BATCH_SIZE
+	}
+	if config.FlushInterval <= 0 {
+		config.FlushInterval = DEFAULT_DATADOG_FLUSH_INTERVAL
+	}
+	if config.RetryBackoff <= 0 {
+		config.RetryBackoff = DEFAULT_DATADOG_RETRY_BACKOFF
+	}
+	if config.MaxSpillBytes <= 0 {
+		config.MaxSpillBytes = DEFAULT_DATADOG_MAX_SPILL_BYTES
+	}
+
+	ds := &DatadogSink{
+		config:   config,
+		logger:   logger.Session("datadog"),
+		pending:  map[string][]interface{}{},
+		flushNow: make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go ds.run()
+	return ds
+}
+
+func (ds *DatadogSink) Emit(response benchmark.BenchmarkResponse, tags []string) error {
+	ds.enqueue(ds.config.Encoder.EncodeResponse(response, tags))
+	return nil
+}
+
+func (ds *DatadogSink) EmitFailure(err error, tags []string) error {
+	ds.enqueue(ds.config.Encoder.EncodeFailure(err, tags))
+	return nil
+}
+
+func (ds *DatadogSink) EmitSummary(summary Summary, tags []string) error {
+	ds.enqueue(ds.config.Encoder.EncodeSummary(summary, tags))
+	return nil
+}
+
+func (ds *DatadogSink) EmitDelivery(delivery benchmark.EnvelopeDelivery, tags []string) error {
+	ds.enqueue(ds.config.Encoder.EncodeDelivery(delivery, tags))
+	return nil
+}
+
+func (ds *DatadogSink) SubmitMetadata() error {
+	if ds.config.AppKey == "" {
+		return errors.New("an application key is required to submit metric metadata")
+	}
+
+	for metric, metadata := range ds.config.Encoder.Metadata() {
+		buf, err := json.Marshal(metadata)
+		if err != nil {
+			return err
+		}
+
+		url := ds.config.APIURL + "/metrics/" + metric + "?api_key=" + ds.config.APIKey + "&application_key=" + ds.config.AppKey
+		req, err := http.NewRequest("PUT", url, bytes.NewReader(buf))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		respBody, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		if resp.StatusCode/100 != 2 {
+			return fmt.Errorf("datadog rejected metadata for %s with %d: %s", metric, resp.StatusCode, respBody)
+		}
+		ds.logger.Info("metadata-submitted", lager.Data{"metric": metric, "type": metadata.Type, "unit": metadata.Unit})
+	}
+	return nil
+}
+
+func (ds *DatadogSink) Flush() error {
+	ds.sendMutex.Lock()
+	defer ds.sendMutex.Unlock()
+
+	ds.pendingMutex.Lock()
+	batches := ds.pending
+	ds.pending = map[string][]interface{}{}
+	ds.pendingCount = 0
+	ds.pendingMutex.Unlock()
+
+	var lastErr error
+	for _, endpoint := range []string{DATADOG_SERIES_ENDPOINT, DATADOG_DISTRIBUTION_ENDPOINT} {
+		batch := batches[endpoint]
+		if len(batch) == 0 {
+			continue
+		}
+
+		err := ds.deliver(endpoint, batch, ds.config.MaxRetries)
+		if err != nil {
+			if _, ok := err.(retryableError); !ok {
+				ds.drop("dropping-rejected-metrics", err, lager.Data{"series": len(batch)})
+			} else if ds.config.SpillDir == "" {
+				ds.drop("dropping-undeliverable-metrics", err, lager.Data{"series": len(batch)})
+			} else if spillErr := ds.spill(endpoint, batch); spillErr != nil {
+				ds.drop("cannot-spill-metrics", spillErr, lager.Data{"series": len(batch)})
+			}
+			lastErr = err
+		}
+	}
+	if lastErr != nil {
+		return lastErr
+	}
+
+	return ds.replay()
+}
+
+// DroppedBatches is the number of batches given up on, whether datadog
+// rejected them, they could not be spilled or the spill buffer overflowed.
+func (ds *DatadogSink) DroppedBatches() uint64 {
+	return atomic.LoadUint64(&ds.dropped)
+}
+
+func (ds *DatadogSink) drop(message string, err error, data lager.Data) {
+	atomic.AddUint64(&ds.dropped, 1)
+	ds.logger.Error(message, err, data)
+}
+
+func (ds *DatadogSink) Close() error {
+	close(ds.stop)
+	<-ds.done
+	return ds.Flush()
+}
+
+func (ds *DatadogSink) enqueue(encoded map[string][]map[string]interface{}) {
+	ds.pendingMutex.Lock()
+	for endpoint, series := range encoded {
+		for _, s := range series {
+			ds.pending[endpoint] = append(ds.pending[endpoint], s)
+			ds.pendingCount++
+		}
+	}
+	full := ds.pendingCount >= ds.config.BatchSize
+	ds.pendingMutex.Unlock()
+
+	if full {
+		select {
+		case ds.flushNow <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (ds *DatadogSink) run() {
+	defer close(ds.done)
+	ticker := time.NewTicker(ds.config.FlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-ds.flushNow:
+		case <-ds.stop:
+			return
+		}
+		if err := ds.Flush(); err != nil {
+			ds.logger.Error("flush-failed", err)
+		}
+	}
+}
+
+func (ds *DatadogSink) deliver(endpoint string, series []interface{}, retries int) error {
+	buf, err := json.Marshal(map[string]interface{}{"series": series})
+	if err != nil {
+		return err
+	}
+
+	backoff := ds.config.RetryBackoff
+	for attempt := 0; ; attempt++ {
+		err = ds.post(ds.config.APIURL+"/"+endpoint+"?api_key="+ds.config.APIKey, buf)
+		if _, ok := err.(retryableError); !ok || attempt >= retries {
+			return err
+		}
+
+		ds.logger.Info("retrying", lager.Data{"attempt": attempt + 1, "backoff": backoff.String(), "error": err.Error()})
+		select {
+		case <-time.After(backoff):
+		case <-ds.stop:
+			return err
+		}
+		backoff *= 2
+	}
+}
+
+func (ds *DatadogSink) post(url string, buf []byte) error {
+	resp, err := http.Post(url, "application/json", bytes.NewReader(buf))
+	if err != nil {
+		return retryableError{err}
+	}
+	defer resp.Body.Close()
+
+	respBody, _ := ioutil.ReadAll(resp.Body)
+	ds.logger.Info("metric-emitted", lager.Data{
+		"response-code": resp.StatusCode,
+		"body":          respBody,
+	})
+
+	switch {
+	case resp.StatusCode/100 == 2:
+		return nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return retryableError{fmt.Errorf("datadog responded with %d: %s", resp.StatusCode, respBody)}
+	default:
+		return fmt.Errorf("datadog rejected metrics with %d: %s", resp.StatusCode, respBody)
+	}
+}
+
+type spilledBatch struct {
+	Endpoint string        `json:"endpoint"`
+	Series   []interface{} `json:"series"`
+}
+
+func (ds *DatadogSink) spill(endpoint string, series []interface{}) error {
+	if ds.config.SpillDir == "" {
+		return errors.New("no spill directory configured")
+	}
+	if err := os.MkdirAll(ds.config.SpillDir, 0755); err != nil {
+		return err
+	}
+
+	buf, err := json.Marshal(spilledBatch{Endpoint: endpoint, Series: series})
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(ds.config.SpillDir, fmt.Sprintf("%020d.json", time.Now().UnixNano()))
+	if err := ioutil.WriteFile(path, buf, 0644); err != nil {
+		return err
+	}
+	ds.logger.Info("spilled-metrics", lager.Data{"series": len(series), "path": path})
+
+	return ds.trimSpill()
+}
+
+func (ds *DatadogSink) trimSpill() error {
+	files, err := ds.spilledFiles()
+	if err != nil {
+		return err
+	}
+
+	var total int64
+	sizes := make([]int64, len(files))
+	for i, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return err
+		}
+		sizes[i] = info.Size()
+		total += sizes[i]
+	}
+
+	for i := 0; total > ds.config.MaxSpillBytes && i < len(files)-1; i++ {
+		ds.drop("dropping-spilled-metrics", errors.New("spill buffer is full"), lager.Data{"path": files[i]})
+		if err := os.Remove(files[i]); err != nil {
+			return err
+		}
+		total -= sizes[i]
+	}
+	return nil
+}
+
+func (ds *DatadogSink) replay() error {
+	if ds.config.SpillDir == "" {
+		return nil
+	}
+
+	files, err := ds.spilledFiles()
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		buf, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+
+		var batch spilledBatch
+		if err := json.Unmarshal(buf, &batch); err != nil {
+			ds.drop("dropping-corrupt-spill-file", err, lager.Data{"path": file})
+			os.Remove(file)
+			continue
+		}
+
+		err = ds.deliver(batch.Endpoint, batch.Series, 0)
+		if _, ok := err.(retryableError); ok {
+			return err
+		}
+		if err != nil {
+			ds.drop("dropping-rejected-metrics", err, lager.Data{"path": file})
+		} else {
+			ds.logger.Info("replayed-spilled-metrics", lager.Data{"series": len(batch.Series), "path": file})
+		}
+		if err := os.Remove(file); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ds *DatadogSink) spilledFiles() ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(ds.config.SpillDir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+	return files, nil
+}
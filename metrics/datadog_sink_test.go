@@ -0,0 +1,252 @@
+package metrics_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/cloudfoundry-incubator/thoth/benchmark"
+	. "github.com/cloudfoundry-incubator/thoth/metrics"
+	"github.com/pivotal-golang/lager/lagertest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/ghttp"
+)
+
+var _ = Describe("DatadogSink", func() {
+	var (
+		server   *ghttp.Server
+		config   DatadogSinkConfig
+		sink     *DatadogSink
+		response benchmark.BenchmarkResponse
+		spillDir string
+	)
+
+	type payload struct {
+		Series []struct {
+			Metric string    `json:"metric"`
+			Points [][]int64 `json:"points"`
+			Tags   []string  `json:"tags"`
+		} `json:"series"`
+	}
+
+	receivedSeries := func(r *http.Request) payload {
+		var p payload
+		Expect(json.NewDecoder(r.Body).Decode(&p)).To(Succeed())
+		return p
+	}
+
+	BeforeEach(func() {
+		server = ghttp.NewServer()
+
+		var err error
+		spillDir, err = ioutil.TempDir("", "datadog-spill")
+		Expect(err).NotTo(HaveOccurred())
+
+		config = DatadogSinkConfig{
+			APIURL:        server.URL() + "/api/v1",
+			APIKey:        "key",
+			AppKey:        "app-key",
+			Encoder:       DatadogEncoder{Unit: DatadogNanoseconds},
+			BatchSize:     100,
+			FlushInterval: time.Hour,
+			MaxRetries:    2,
+			RetryBackoff:  time.Millisecond,
+			SpillDir:      spillDir,
+		}
+
+		response = benchmark.BenchmarkResponse{
+			ResponseCode:  http.StatusOK,
+			TotalRoundrip: 50 * time.Millisecond,
+			Timestamp:     time.Unix(123456789, 0),
+		}
+	})
+
+	JustBeforeEach(func() {
+		sink = NewDatadogSink(config, lagertest.NewTestLogger("test"))
+	})
+
+	AfterEach(func() {
+		sink.Close()
+		server.Close()
+		os.RemoveAll(spillDir)
+	})
+
+	It("batches the series of many samples into one request", func() {
+		server.AppendHandlers(ghttp.CombineHandlers(
+			ghttp.VerifyRequest("POST", "/api/v1/series", "api_key=key"),
+			ghttp.VerifyContentType("application/json"),
+			func(w http.ResponseWriter, r *http.Request) {
+				Expect(receivedSeries(r).Series).To(HaveLen(22))
+			},
+			ghttp.RespondWith(http.StatusAccepted, "{}"),
+		))
+
+		Expect(sink.Emit(response, []string{"index:0"})).To(Succeed())
+		Expect(sink.Emit(response, []string{"index:1"})).To(Succeed())
+		Expect(server.ReceivedRequests()).To(BeEmpty())
+
+		Expect(sink.Flush()).To(Succeed())
+		Expect(server.ReceivedRequests()).To(HaveLen(1))
+	})
+
+	Context("when sending distributions", func() {
+		BeforeEach(func() {
+			config.Encoder = DatadogEncoder{Distribution: true, Unit: DatadogMilliseconds}
+		})
+
+		It("posts the phases as distribution points and the outcome as a series", func() {
+			server.AppendHandlers(
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("POST", "/api/v1/series", "api_key=key"),
+					func(w http.ResponseWriter, r *http.Request) {
+						Expect(receivedSeries(r).Series).To(HaveLen(1))
+					},
+				),
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("POST", "/api/v1/distribution_points", "api_key=key"),
+					func(w http.ResponseWriter, r *http.Request) {
+						var p struct {
+							Series []struct {
+								Metric string          `json:"metric"`
+								Points [][]interface{} `json:"points"`
+							} `json:"series"`
+						}
+						Expect(json.NewDecoder(r.Body).Decode(&p)).To(Succeed())
+						Expect(p.Series).To(HaveLen(10))
+						Expect(p.Series[0].Metric).To(Equal("app_benchmarking.total_roundtrip"))
+						Expect(p.Series[0].Points).To(Equal([][]interface{}{{float64(123456789), []interface{}{float64(50)}}}))
+					},
+				),
+			)
+
+			Expect(sink.Emit(response, nil)).To(Succeed())
+			Expect(sink.Flush()).To(Succeed())
+			Expect(server.ReceivedRequests()).To(HaveLen(2))
+		})
+
+		It("submits distribution metadata with the configured unit", func() {
+			for i := 0; i < 12; i++ {
+				server.AppendHandlers(ghttp.CombineHandlers(
+					ghttp.VerifyRequest("PUT", MatchRegexp("/api/v1/metrics/app_benchmarking\\..*"), "api_key=key&application_key=app-key"),
+					ghttp.VerifyJSON(`{"type":"distribution","unit":"millisecond"}`),
+				))
+			}
+
+			Expect(sink.SubmitMetadata()).To(Succeed())
+			Expect(server.ReceivedRequests()).To(HaveLen(12))
+		})
+	})
+
+	Context("when the batch is full", func() {
+		BeforeEach(func() {
+			config.BatchSize = 10
+		})
+
+		It("sends without waiting for the flush interval", func() {
+			server.AppendHandlers(ghttp.RespondWith(http.StatusAccepted, "{}"))
+
+			Expect(sink.Emit(response, nil)).To(Succeed())
+			Eventually(server.ReceivedRequests).Should(HaveLen(1))
+		})
+	})
+
+	It("retries with backoff when datadog is unavailable", func() {
+		server.AppendHandlers(
+			ghttp.RespondWith(http.StatusServiceUnavailable, ""),
+			ghttp.RespondWith(http.StatusTooManyRequests, ""),
+			ghttp.RespondWith(http.StatusAccepted, "{}"),
+		)
+
+		Expect(sink.Emit(response, nil)).To(Succeed())
+		Expect(sink.Flush()).To(Succeed())
+		Expect(server.ReceivedRequests()).To(HaveLen(3))
+	})
+
+	It("does not retry rejected metrics", func() {
+		server.AppendHandlers(ghttp.RespondWith(http.StatusBadRequest, "bad metric"))
+
+		Expect(sink.Emit(response, nil)).To(Succeed())
+		Expect(sink.Flush()).To(MatchError(ContainSubstring("bad metric")))
+		Expect(server.ReceivedRequests()).To(HaveLen(1))
+		Expect(sink.DroppedBatches()).To(Equal(uint64(1)))
+
+		files, _ := filepath.Glob(filepath.Join(spillDir, "*"))
+		Expect(files).To(BeEmpty())
+	})
+
+	Context("when retries are exhausted", func() {
+		BeforeEach(func() {
+			server.AppendHandlers(
+				ghttp.RespondWith(http.StatusInternalServerError, ""),
+				ghttp.RespondWith(http.StatusInternalServerError, ""),
+				ghttp.RespondWith(http.StatusInternalServerError, ""),
+			)
+		})
+
+		It("spills to disk and replays with the original timestamps once datadog recovers", func() {
+			Expect(sink.Emit(response, []string{"index:0"})).To(Succeed())
+			Expect(sink.Flush()).NotTo(Succeed())
+
+			files, _ := filepath.Glob(filepath.Join(spillDir, "*.json"))
+			Expect(files).To(HaveLen(1))
+
+			server.AppendHandlers(
+				ghttp.RespondWith(http.StatusAccepted, "{}"),
+				func(w http.ResponseWriter, r *http.Request) {
+					p := receivedSeries(r)
+					Expect(p.Series).To(HaveLen(11))
+					Expect(p.Series[0].Points[0][0]).To(Equal(int64(123456789)))
+					Expect(p.Series[0].Tags).To(Equal([]string{"status:200", "outcome:success", "index:0"}))
+				},
+			)
+
+			later := response
+			later.Timestamp = time.Unix(223456789, 0)
+			Expect(sink.Emit(later, nil)).To(Succeed())
+			Expect(sink.Flush()).To(Succeed())
+			Expect(server.ReceivedRequests()).To(HaveLen(5))
+
+			files, _ = filepath.Glob(filepath.Join(spillDir, "*.json"))
+			Expect(files).To(BeEmpty())
+		})
+
+		Context("and the spill buffer is full", func() {
+			BeforeEach(func() {
+				config.MaxRetries = 0
+				config.MaxSpillBytes = 1
+			})
+
+			It("drops the oldest spilled batches but keeps the newest", func() {
+				Expect(sink.Emit(response, nil)).To(Succeed())
+				Expect(sink.Flush()).NotTo(Succeed())
+				files, _ := filepath.Glob(filepath.Join(spillDir, "*.json"))
+				Expect(files).To(HaveLen(1))
+				first := files[0]
+
+				Expect(sink.Emit(response, nil)).To(Succeed())
+				Expect(sink.Flush()).NotTo(Succeed())
+				files, _ = filepath.Glob(filepath.Join(spillDir, "*.json"))
+				Expect(files).To(HaveLen(1))
+				Expect(files[0]).NotTo(Equal(first))
+				Expect(sink.DroppedBatches()).To(Equal(uint64(1)))
+			})
+		})
+
+		Context("and there is no spill directory", func() {
+			BeforeEach(func() {
+				config.SpillDir = ""
+			})
+
+			It("counts the batch as dropped", func() {
+				Expect(sink.Emit(response, nil)).To(Succeed())
+				Expect(sink.Flush()).NotTo(Succeed())
+				Expect(sink.DroppedBatches()).To(Equal(uint64(1)))
+			})
+		})
+	})
+})
@@ -0,0 +1,209 @@
+package metrics
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudfoundry-incubator/thoth/benchmark"
+	"github.com/pivotal-golang/lager"
+)
+
+const (
+	INFLUX_FAILURE_MEASUREMENT  = "app_benchmarking_failure"
+	INFLUX_SUMMARY_MEASUREMENT  = "app_benchmarking_summary"
+	INFLUX_DELIVERY_MEASUREMENT = "app_benchmarking_envelope"
+
+	INFLUX_QUEUE_SIZE   = 1024
+	INFLUX_BATCH_SIZE   = 100
+	INFLUX_HTTP_TIMEOUT = 10 * time.Second
+)
+
+// influxItem is a queued line, or a flush request when flushed is set.
+type influxItem struct {
+	line    string
+	flushed chan struct{}
+}
+
+// InfluxSink queues lines and writes them in batches from a background
+// goroutine, so a slow destination never blocks the measurers or the hub.
+type InfluxSink struct {
+	write  func([]byte) error
+	close  func() error
+	queue  chan influxItem
+	done   chan struct{}
+	logger lager.Logger
+
+	mutex  sync.RWMutex
+	closed bool
+}
+
+func newInfluxSink(write func([]byte) error, close func() error, logger lager.Logger) *InfluxSink {
+	is := &InfluxSink{
+		write:  write,
+		close:  close,
+		queue:  make(chan influxItem, INFLUX_QUEUE_SIZE),
+		done:   make(chan struct{}),
+		logger: logger.Session("influx"),
+	}
+	go is.run()
+	return is
+}
+
+func NewInfluxSink(destination string, logger lager.Logger) (*InfluxSink, error) {
+	if strings.HasPrefix(destination, "http://") || strings.HasPrefix(destination, "https://") {
+		return NewInfluxHTTPSink(destination, logger), nil
+	}
+	return NewInfluxFileSink(destination, logger)
+}
+
+func NewInfluxHTTPSink(writeUrl string, logger lager.Logger) *InfluxSink {
+	client := &http.Client{Timeout: INFLUX_HTTP_TIMEOUT}
+	return newInfluxSink(
+		func(body []byte) error {
+			resp, err := client.Post(writeUrl, "text/plain", bytes.NewReader(body))
+			if err != nil {
+				return err
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode/100 != 2 {
+				respBody, _ := ioutil.ReadAll(resp.Body)
+				return errors.New("influx write failed with status " + strconv.Itoa(resp.StatusCode) + ": " + string(respBody))
+			}
+			return nil
+		},
+		func() error { return nil },
+		logger,
+	)
+}
+
+func NewInfluxFileSink(path string, logger lager.Logger) (*InfluxSink, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	return newInfluxSink(
+		func(body []byte) error {
+			_, err := file.Write(body)
+			return err
+		},
+		file.Close,
+		logger,
+	), nil
+}
+
+func (is *InfluxSink) Emit(response benchmark.BenchmarkResponse, tags []string) error {
+	return is.writeLine(response.ToInflux(tags))
+}
+
+func (is *InfluxSink) EmitFailure(err error, tags []string) error {
+	fields := []string{
+		"failed=1i",
+		"timed_out=" + strconv.FormatBool(benchmark.IsTimeout(err)),
+	}
+	tags = append(benchmark.FailureTags(err), tags...)
+	return is.writeLine(benchmark.InfluxLine(INFLUX_FAILURE_MEASUREMENT, tags, fields, benchmark.FailureTime(err)))
+}
+
+func (is *InfluxSink) EmitSummary(summary Summary, tags []string) error {
+	return is.writeLine(strings.Join(summary.ToInflux(tags), "\n"))
+}
+
+func (is *InfluxSink) EmitDelivery(delivery benchmark.EnvelopeDelivery, tags []string) error {
+	fields := []string{"count=1i"}
+	for _, latency := range delivery.Latencies() {
+		fields = append(fields, latency.Name+"_latency="+strconv.FormatInt(latency.Duration.Nanoseconds(), 10)+"i")
+	}
+	tags = append(delivery.Tags(), tags...)
+	return is.writeLine(benchmark.InfluxLine(INFLUX_DELIVERY_MEASUREMENT, tags, fields, delivery.Timestamp))
+}
+
+// Flush waits until every line queued so far has been written.
+func (is *InfluxSink) Flush() error {
+	is.mutex.RLock()
+	if is.closed {
+		is.mutex.RUnlock()
+		return nil
+	}
+	flushed := make(chan struct{})
+	is.queue <- influxItem{flushed: flushed}
+	is.mutex.RUnlock()
+
+	<-flushed
+	return nil
+}
+
+func (is *InfluxSink) Close() error {
+	is.mutex.Lock()
+	if is.closed {
+		is.mutex.Unlock()
+		return errors.New("influx sink is already closed")
+	}
+	is.closed = true
+	close(is.queue)
+	is.mutex.Unlock()
+
+	<-is.done
+	return is.close()
+}
+
+func (is *InfluxSink) writeLine(line string) error {
+	is.mutex.RLock()
+	defer is.mutex.RUnlock()
+
+	if is.closed {
+		return errors.New("influx sink is closed, dropping metrics")
+	}
+	select {
+	case is.queue <- influxItem{line: line}:
+		return nil
+	default:
+		return errors.New("influx queue is full, dropping metrics")
+	}
+}
+
+func (is *InfluxSink) run() {
+	defer close(is.done)
+	for item := range is.queue {
+		lines, flushes := is.batch(item)
+		if len(lines) > 0 {
+			if err := is.write([]byte(strings.Join(lines, "\n") + "\n")); err != nil {
+				is.logger.Error("cannot-emit-metric", err, lager.Data{"lines": len(lines)})
+			}
+		}
+		for _, flushed := range flushes {
+			close(flushed)
+		}
+	}
+}
+
+// batch collects the items already queued after first, up to
+// INFLUX_BATCH_SIZE lines.
+func (is *InfluxSink) batch(first influxItem) ([]string, []chan struct{}) {
+	lines, flushes := []string{}, []chan struct{}{}
+	item, ok := first, true
+	for ok {
+		if item.flushed != nil {
+			flushes = append(flushes, item.flushed)
+		} else {
+			lines = append(lines, item.line)
+		}
+		if len(lines) >= INFLUX_BATCH_SIZE {
+			break
+		}
+		select {
+		case item, ok = <-is.queue:
+		default:
+			ok = false
+		}
+	}
+	return lines, flushes
+}
@@ -0,0 +1,118 @@
+package metrics_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/cloudfoundry-incubator/thoth/benchmark"
+	. "github.com/cloudfoundry-incubator/thoth/metrics"
+	"github.com/pivotal-golang/lager/lagertest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+	"github.com/onsi/gomega/ghttp"
+)
+
+var _ = Describe("InfluxSink", func() {
+	var response benchmark.BenchmarkResponse
+
+	BeforeEach(func() {
+		response = benchmark.BenchmarkResponse{
+			ResponseCode:  http.StatusOK,
+			TotalRoundrip: 50 * time.Millisecond,
+			Timestamp:     time.Unix(123456789, 0),
+		}
+	})
+
+	Context("writing to an HTTP endpoint", func() {
+		var server *ghttp.Server
+
+		BeforeEach(func() {
+			server = ghttp.NewServer()
+		})
+
+		AfterEach(func() {
+			server.Close()
+		})
+
+		It("posts the line protocol", func() {
+			server.AppendHandlers(ghttp.CombineHandlers(
+				ghttp.VerifyRequest("POST", "/write", "db=thoth&precision=ns"),
+				func(w http.ResponseWriter, r *http.Request) {
+					body, err := ioutil.ReadAll(r.Body)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(string(body)).To(Equal(response.ToInflux(nil) + "\n"))
+				},
+				ghttp.RespondWith(http.StatusNoContent, nil),
+			))
+
+			sink, err := NewInfluxSink(server.URL()+"/write?db=thoth&precision=ns", lagertest.NewTestLogger("test"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(sink.Emit(response, []string{})).To(Succeed())
+			Expect(sink.Flush()).To(Succeed())
+			Expect(server.ReceivedRequests()).To(HaveLen(1))
+		})
+
+		It("logs writes that are rejected", func() {
+			server.AppendHandlers(ghttp.RespondWith(http.StatusBadRequest, "bad line"))
+
+			logger := lagertest.NewTestLogger("test")
+			sink, err := NewInfluxSink(server.URL()+"/write", logger)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(sink.Emit(response, nil)).To(Succeed())
+			Expect(sink.Flush()).To(Succeed())
+			Expect(logger).To(gbytes.Say("cannot-emit-metric.*bad line"))
+		})
+
+		It("does not block emitting while the endpoint hangs", func() {
+			unblock := make(chan struct{})
+			server.AllowUnhandledRequests = true
+			server.AppendHandlers(func(w http.ResponseWriter, r *http.Request) {
+				<-unblock
+			})
+			defer close(unblock)
+
+			sink, err := NewInfluxSink(server.URL()+"/write", lagertest.NewTestLogger("test"))
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(sink.Emit(response, nil)).To(Succeed())
+			Eventually(server.ReceivedRequests).Should(HaveLen(1))
+			Expect(sink.Emit(response, nil)).To(Succeed())
+			Expect(sink.Emit(response, nil)).To(Succeed())
+		})
+	})
+
+	Context("appending to a file", func() {
+		var dir string
+
+		BeforeEach(func() {
+			var err error
+			dir, err = ioutil.TempDir("", "influx")
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(dir)
+		})
+
+		It("appends one line per response", func() {
+			path := filepath.Join(dir, "thoth.influx")
+			sink, err := NewInfluxSink(path, lagertest.NewTestLogger("test"))
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(sink.Emit(response, []string{"index:0"})).To(Succeed())
+			Expect(sink.Emit(response, []string{"index:1"})).To(Succeed())
+			Expect(sink.Close()).To(Succeed())
+
+			contents, err := ioutil.ReadFile(path)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(contents)).To(Equal(
+				response.ToInflux([]string{"index:0"}) + "\n" + response.ToInflux([]string{"index:1"}) + "\n",
+			))
+		})
+	})
+})
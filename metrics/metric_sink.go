@@ -0,0 +1,84 @@
+package metrics
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/cloudfoundry-incubator/thoth/benchmark"
+)
+
+type MetricSink interface {
+	Emit(response benchmark.BenchmarkResponse, tags []string) error
+	EmitFailure(err error, tags []string) error
+	Flush() error
+	Close() error
+}
+
+// DeliverySink is implemented by sinks that report the fate of every envelope
+// a request waited for: on time, late after the request timed out, or lost.
+type DeliverySink interface {
+	EmitDelivery(delivery benchmark.EnvelopeDelivery, tags []string) error
+}
+
+type MultiSink struct {
+	sinks []MetricSink
+}
+
+func NewMultiSink(sinks ...MetricSink) *MultiSink {
+	return &MultiSink{sinks: sinks}
+}
+
+func (ms *MultiSink) Emit(response benchmark.BenchmarkResponse, tags []string) error {
+	return ms.each(func(s MetricSink) error {
+		return s.Emit(response, tags)
+	})
+}
+
+func (ms *MultiSink) EmitFailure(err error, tags []string) error {
+	return ms.each(func(s MetricSink) error {
+		return s.EmitFailure(err, tags)
+	})
+}
+
+func (ms *MultiSink) EmitSummary(summary Summary, tags []string) error {
+	return ms.each(func(s MetricSink) error {
+		if summarySink, ok := s.(SummarySink); ok {
+			return summarySink.EmitSummary(summary, tags)
+		}
+		return nil
+	})
+}
+
+func (ms *MultiSink) EmitDelivery(delivery benchmark.EnvelopeDelivery, tags []string) error {
+	return ms.each(func(s MetricSink) error {
+		if deliverySink, ok := s.(DeliverySink); ok {
+			return deliverySink.EmitDelivery(delivery, tags)
+		}
+		return nil
+	})
+}
+
+func (ms *MultiSink) Flush() error {
+	return ms.each(func(s MetricSink) error {
+		return s.Flush()
+	})
+}
+
+func (ms *MultiSink) Close() error {
+	return ms.each(func(s MetricSink) error {
+		return s.Close()
+	})
+}
+
+func (ms *MultiSink) each(f func(MetricSink) error) error {
+	messages := []string{}
+	for _, s := range ms.sinks {
+		if err := f(s); err != nil {
+			messages = append(messages, err.Error())
+		}
+	}
+	if len(messages) > 0 {
+		return errors.New(strings.Join(messages, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package metrics_test
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/cloudfoundry-incubator/thoth/benchmark"
+	. "github.com/cloudfoundry-incubator/thoth/metrics"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type fakeSink struct {
+	emitted []benchmark.BenchmarkResponse
+	tags    [][]string
+	failed  []error
+	flushes int
+	closes  int
+	err     error
+}
+
+func (fs *fakeSink) Emit(response benchmark.BenchmarkResponse, tags []string) error {
+	fs.emitted = append(fs.emitted, response)
+	fs.tags = append(fs.tags, tags)
+	return fs.err
+}
+
+func (fs *fakeSink) EmitFailure(err error, tags []string) error {
+	fs.failed = append(fs.failed, err)
+	return fs.err
+}
+
+func (fs *fakeSink) Flush() error {
+	fs.flushes++
+	return fs.err
+}
+
+func (fs *fakeSink) Close() error {
+	fs.closes++
+	return fs.err
+}
+
+type fakeDeliverySink struct {
+	fakeSink
+	delivered []benchmark.EnvelopeDelivery
+}
+
+func (fs *fakeDeliverySink) EmitDelivery(delivery benchmark.EnvelopeDelivery, tags []string) error {
+	fs.delivered = append(fs.delivered, delivery)
+	return fs.err
+}
+
+var _ = Describe("MultiSink", func() {
+	var (
+		first, second *fakeSink
+		sink          *MultiSink
+		response      benchmark.BenchmarkResponse
+	)
+
+	BeforeEach(func() {
+		first = &fakeSink{}
+		second = &fakeSink{}
+		sink = NewMultiSink(first, second)
+		response = benchmark.BenchmarkResponse{ResponseCode: http.StatusOK}
+	})
+
+	It("emits to every sink", func() {
+		Expect(sink.Emit(response, []string{"index:0"})).To(Succeed())
+		Expect(first.emitted).To(Equal([]benchmark.BenchmarkResponse{response}))
+		Expect(second.emitted).To(Equal([]benchmark.BenchmarkResponse{response}))
+		Expect(second.tags).To(Equal([][]string{{"index:0"}}))
+	})
+
+	It("forwards deliveries to the sinks that report them", func() {
+		delivery := benchmark.EnvelopeDelivery{Status: benchmark.DeliveryLost}
+		deliverySink := &fakeDeliverySink{}
+		Expect(NewMultiSink(first, deliverySink).EmitDelivery(delivery, []string{"index:0"})).To(Succeed())
+		Expect(deliverySink.delivered).To(Equal([]benchmark.EnvelopeDelivery{delivery}))
+	})
+
+	It("flushes and closes every sink", func() {
+		Expect(sink.Flush()).To(Succeed())
+		Expect(sink.Close()).To(Succeed())
+		Expect(first.flushes).To(Equal(1))
+		Expect(second.closes).To(Equal(1))
+	})
+
+	Context("when a sink fails", func() {
+		BeforeEach(func() {
+			first.err = errors.New("potato")
+		})
+
+		It("still emits to the remaining sinks and returns the error", func() {
+			err := sink.Emit(response, nil)
+			Expect(err).To(MatchError("potato"))
+			Expect(second.emitted).To(HaveLen(1))
+		})
+	})
+})
@@ -0,0 +1,214 @@
+package metrics
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudfoundry-incubator/thoth/benchmark"
+)
+
+const prometheusNamespace = "app_benchmarking"
+
+var (
+	DefaultLatencyBuckets = []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+	invalidLabelChars = regexp.MustCompile("[^a-zA-Z0-9_]")
+	labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+)
+
+type histogram struct {
+	name    string
+	labels  string
+	buckets []uint64
+	sum     float64
+	count   uint64
+}
+
+type counter struct {
+	name   string
+	labels string
+	value  uint64
+}
+
+type PrometheusSink struct {
+	mutex      sync.Mutex
+	buckets    []float64
+	histograms map[string]*histogram
+	counters   map[string]*counter
+}
+
+func NewPrometheusSink(buckets []float64) *PrometheusSink {
+	return &PrometheusSink{
+		buckets:    buckets,
+		histograms: map[string]*histogram{},
+		counters:   map[string]*counter{},
+	}
+}
+
+func (ps *PrometheusSink) Emit(response benchmark.BenchmarkResponse, tags []string) error {
+	labels := prometheusLabels(append(response.Tags(), tags...))
+
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
+
+	for _, phase := range response.Phases() {
+		ps.observe(phase.Name+"_seconds", labels, phase.Duration)
+	}
+	ps.increment("benchmarks_total", labels)
+	if response.Outcome() != benchmark.OutcomeSuccess {
+		ps.increment("failed_benchmarks_total", labels)
+	}
+	return nil
+}
+
+func (ps *PrometheusSink) EmitFailure(err error, tags []string) error {
+	labels := prometheusLabels(append(benchmark.FailureTags(err), tags...))
+
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
+
+	ps.increment("benchmarks_total", labels)
+	ps.increment("failed_benchmarks_total", labels)
+	if benchmark.IsTimeout(err) {
+		ps.increment("timed_out_benchmarks_total", labels)
+	}
+	return nil
+}
+
+func (ps *PrometheusSink) EmitDelivery(delivery benchmark.EnvelopeDelivery, tags []string) error {
+	labels := prometheusLabels(append(delivery.Tags(), tags...))
+
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
+
+	ps.increment("envelopes_total", labels)
+	for _, latency := range delivery.Latencies() {
+		ps.observe("log_delivery_latency_seconds", withLabel(labels, "since", latency.Name), latency.Duration)
+	}
+	return nil
+}
+
+func (ps *PrometheusSink) Flush() error {
+	return nil
+}
+
+func (ps *PrometheusSink) Close() error {
+	return nil
+}
+
+func (ps *PrometheusSink) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	keys := []string{}
+	for key := range ps.histograms {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	lastName := ""
+	for _, key := range keys {
+		h := ps.histograms[key]
+		fullName := prometheusNamespace + "_" + h.name
+		if h.name != lastName {
+			fmt.Fprintf(w, "# TYPE %s histogram\n", fullName)
+			lastName = h.name
+		}
+		for i, upperBound := range ps.buckets {
+			fmt.Fprintf(w, "%s_bucket{%s} %d\n", fullName, withLabel(h.labels, "le", formatFloat(upperBound)), h.buckets[i])
+		}
+		fmt.Fprintf(w, "%s_bucket{%s} %d\n", fullName, withLabel(h.labels, "le", "+Inf"), h.count)
+		fmt.Fprintf(w, "%s_sum{%s} %s\n", fullName, h.labels, formatFloat(h.sum))
+		fmt.Fprintf(w, "%s_count{%s} %d\n", fullName, h.labels, h.count)
+	}
+
+	keys = []string{}
+	for key := range ps.counters {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	lastName = ""
+	for _, key := range keys {
+		c := ps.counters[key]
+		fullName := prometheusNamespace + "_" + c.name
+		if c.name != lastName {
+			fmt.Fprintf(w, "# TYPE %s counter\n", fullName)
+			lastName = c.name
+		}
+		fmt.Fprintf(w, "%s{%s} %d\n", fullName, c.labels, c.value)
+	}
+}
+
+func (ps *PrometheusSink) observe(name, labels string, d time.Duration) {
+	h, ok := ps.histograms[name+"{"+labels]
+	if !ok {
+		h = &histogram{name: name, labels: labels, buckets: make([]uint64, len(ps.buckets))}
+		ps.histograms[name+"{"+labels] = h
+	}
+
+	value := d.Seconds()
+	for i, upperBound := range ps.buckets {
+		if value <= upperBound {
+			h.buckets[i]++
+		}
+	}
+	h.sum += value
+	h.count++
+}
+
+func (ps *PrometheusSink) increment(name, labels string) {
+	c, ok := ps.counters[name+"{"+labels]
+	if !ok {
+		c = &counter{name: name, labels: labels}
+		ps.counters[name+"{"+labels] = c
+	}
+	c.value++
+}
+
+func prometheusLabels(tags []string) string {
+	values := map[string]string{}
+	for _, tag := range tags {
+		parts := strings.SplitN(tag, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		values[invalidLabelChars.ReplaceAllString(parts[0], "_")] = parts[1]
+	}
+
+	names := []string{}
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	labels := []string{}
+	for _, name := range names {
+		labels = append(labels, name+"="+quoteLabelValue(values[name]))
+	}
+	return strings.Join(labels, ",")
+}
+
+func withLabel(labels, name, value string) string {
+	label := name + "=" + quoteLabelValue(value)
+	if labels == "" {
+		return label
+	}
+	return labels + "," + label
+}
+
+func quoteLabelValue(value string) string {
+	return `"` + labelValueEscaper.Replace(value) + `"`
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
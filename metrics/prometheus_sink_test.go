@@ -0,0 +1,116 @@
+package metrics_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/cloudfoundry-incubator/thoth/benchmark"
+	. "github.com/cloudfoundry-incubator/thoth/metrics"
+	"github.com/cloudfoundry/sonde-go/events"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("PrometheusSink", func() {
+	var sink *PrometheusSink
+
+	scrape := func() string {
+		recorder := httptest.NewRecorder()
+		sink.ServeHTTP(recorder, &http.Request{})
+		body, err := ioutil.ReadAll(recorder.Body)
+		Expect(err).NotTo(HaveOccurred())
+		return string(body)
+	}
+
+	BeforeEach(func() {
+		sink = NewPrometheusSink([]float64{0.01, 0.1})
+	})
+
+	It("exposes phase histograms labelled by tags", func() {
+		response := benchmark.BenchmarkResponse{
+			ResponseCode:  http.StatusOK,
+			TotalRoundrip: 50 * time.Millisecond,
+			TimeInApp:     5 * time.Millisecond,
+			TimeInRouter:  20 * time.Millisecond,
+			RestOfTime:    25 * time.Millisecond,
+		}
+		Expect(sink.Emit(response, []string{"deployment:cf", "index:0"})).To(Succeed())
+
+		body := scrape()
+		Expect(body).To(ContainSubstring("# TYPE app_benchmarking_total_roundtrip_seconds histogram\n"))
+		Expect(body).To(ContainSubstring(`app_benchmarking_total_roundtrip_seconds_bucket{deployment="cf",index="0",outcome="success",status="200",le="0.01"} 0`))
+		Expect(body).To(ContainSubstring(`app_benchmarking_total_roundtrip_seconds_bucket{deployment="cf",index="0",outcome="success",status="200",le="0.1"} 1`))
+		Expect(body).To(ContainSubstring(`app_benchmarking_total_roundtrip_seconds_bucket{deployment="cf",index="0",outcome="success",status="200",le="+Inf"} 1`))
+		Expect(body).To(ContainSubstring(`app_benchmarking_total_roundtrip_seconds_sum{deployment="cf",index="0",outcome="success",status="200"} 0.05`))
+		Expect(body).To(ContainSubstring(`app_benchmarking_time_in_app_seconds_bucket{deployment="cf",index="0",outcome="success",status="200",le="0.01"} 1`))
+		Expect(body).To(ContainSubstring(`app_benchmarking_time_in_gorouter_seconds_count{deployment="cf",index="0",outcome="success",status="200"} 1`))
+		Expect(body).To(ContainSubstring(`app_benchmarking_rest_of_time_seconds_count{deployment="cf",index="0",outcome="success",status="200"} 1`))
+	})
+
+	It("counts failed and timed out benchmarks by outcome", func() {
+		Expect(sink.EmitFailure(&benchmark.TransportError{Err: errors.New("potato")}, []string{"index:0"})).To(Succeed())
+		Expect(sink.EmitFailure(&benchmark.MissingHttpStartStopError{PartialTiming: benchmark.PartialTiming{ResponseCode: http.StatusOK}}, []string{"index:0"})).To(Succeed())
+
+		body := scrape()
+		Expect(body).To(ContainSubstring("# TYPE app_benchmarking_failed_benchmarks_total counter\n"))
+		Expect(body).To(ContainSubstring(`app_benchmarking_failed_benchmarks_total{index="0",outcome="transport_error"} 1`))
+		Expect(body).To(ContainSubstring(`app_benchmarking_failed_benchmarks_total{index="0",outcome="envelope_timeout",status="200"} 1`))
+		Expect(body).To(ContainSubstring(`app_benchmarking_timed_out_benchmarks_total{index="0",outcome="envelope_timeout",status="200"} 1`))
+		Expect(body).NotTo(ContainSubstring(`app_benchmarking_timed_out_benchmarks_total{index="0",outcome="transport_error"}`))
+	})
+
+	It("counts late and lost envelopes and observes the latency of late ones", func() {
+		late := benchmark.EnvelopeDelivery{EventType: events.Envelope_LogMessage, Status: benchmark.DeliveryLate, Gap: 50 * time.Millisecond}
+		lost := benchmark.EnvelopeDelivery{EventType: events.Envelope_HttpStartStop, Status: benchmark.DeliveryLost}
+		Expect(sink.EmitDelivery(late, []string{"index:0"})).To(Succeed())
+		Expect(sink.EmitDelivery(lost, []string{"index:0"})).To(Succeed())
+
+		body := scrape()
+		Expect(body).To(ContainSubstring(`app_benchmarking_envelopes_total{delivery="late",event_type="log_message",index="0"} 1`))
+		Expect(body).To(ContainSubstring(`app_benchmarking_envelopes_total{delivery="lost",event_type="http_start_stop",index="0"} 1`))
+		Expect(body).To(ContainSubstring(`app_benchmarking_log_delivery_latency_seconds_bucket{delivery="late",event_type="log_message",index="0",since="response",le="0.1"} 1`))
+		Expect(body).NotTo(ContainSubstring(`app_benchmarking_log_delivery_latency_seconds_count{delivery="lost"`))
+	})
+
+	It("observes the delivery latency of envelopes since their origin and since the response", func() {
+		delivery := benchmark.EnvelopeDelivery{
+			EventType: events.Envelope_LogMessage,
+			Status:    benchmark.DeliveryOnTime,
+			Timestamp: time.Unix(100, 0),
+			Origin:    time.Unix(99, 0),
+			Gap:       5 * time.Millisecond,
+		}
+		Expect(sink.EmitDelivery(delivery, []string{"index:0"})).To(Succeed())
+
+		body := scrape()
+		Expect(body).To(ContainSubstring(`app_benchmarking_log_delivery_latency_seconds_sum{delivery="on_time",event_type="log_message",index="0",since="origin"} 1`))
+		Expect(body).To(ContainSubstring(`app_benchmarking_log_delivery_latency_seconds_sum{delivery="on_time",event_type="log_message",index="0",since="response"} 0.005`))
+		Expect(body).To(ContainSubstring(`app_benchmarking_envelopes_total{delivery="on_time",event_type="log_message",index="0"} 1`))
+	})
+
+	It("escapes only backslashes, quotes and newlines in label values", func() {
+		Expect(sink.EmitFailure(&benchmark.TransportError{Err: errors.New("potato")}, []string{"route:a\\b\t\"c\"\nd"})).To(Succeed())
+
+		Expect(scrape()).To(ContainSubstring(`app_benchmarking_benchmarks_total{outcome="transport_error",route="a\\b` + "\t" + `\"c\"\nd"} 1`))
+	})
+
+	It("counts every benchmark alongside its outcome", func() {
+		Expect(sink.Emit(benchmark.BenchmarkResponse{ResponseCode: http.StatusBadGateway}, []string{"index:0"})).To(Succeed())
+		Expect(sink.EmitFailure(&benchmark.ParseError{PartialTiming: benchmark.PartialTiming{ResponseCode: http.StatusOK}}, []string{"index:0"})).To(Succeed())
+
+		body := scrape()
+		Expect(body).To(ContainSubstring(`app_benchmarking_benchmarks_total{index="0",outcome="http_error",status="502"} 1`))
+		Expect(body).To(ContainSubstring(`app_benchmarking_benchmarks_total{index="0",outcome="parse_error",status="200"} 1`))
+		Expect(body).To(ContainSubstring(`app_benchmarking_failed_benchmarks_total{index="0",outcome="http_error",status="502"} 1`))
+	})
+
+	It("does not count successful benchmarks as failed", func() {
+		Expect(sink.Emit(benchmark.BenchmarkResponse{ResponseCode: http.StatusOK}, []string{"index:0"})).To(Succeed())
+
+		Expect(scrape()).NotTo(ContainSubstring("app_benchmarking_failed_benchmarks_total"))
+	})
+})
@@ -0,0 +1,160 @@
+package metrics
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const ROTATED_TIME_FORMAT = "20060102T150405.000000000"
+
+type RotatingFile struct {
+	path     string
+	maxBytes int64
+	maxAge   time.Duration
+	compress bool
+
+	mutex    sync.Mutex
+	file     *os.File
+	size     int64
+	openedAt time.Time
+	now      func() time.Time
+}
+
+func NewRotatingFile(path string, maxBytes int64, maxAge time.Duration, compress bool) (*RotatingFile, error) {
+	rf := &RotatingFile{
+		path:     path,
+		maxBytes: maxBytes,
+		maxAge:   maxAge,
+		compress: compress,
+		now:      time.Now,
+	}
+	if err := rf.open(); err != nil {
+		return nil, err
+	}
+	return rf, nil
+}
+
+func (rf *RotatingFile) Write(p []byte) (int, error) {
+	rf.mutex.Lock()
+	defer rf.mutex.Unlock()
+
+	var rotateErr error
+	if rf.shouldRotate(int64(len(p))) {
+		rotateErr = rf.rotate()
+	}
+
+	n, err := rf.file.Write(p)
+	rf.size += int64(n)
+	if err == nil {
+		err = rotateErr
+	}
+	return n, err
+}
+
+func (rf *RotatingFile) Sync() error {
+	rf.mutex.Lock()
+	defer rf.mutex.Unlock()
+	return rf.file.Sync()
+}
+
+func (rf *RotatingFile) Close() error {
+	rf.mutex.Lock()
+	defer rf.mutex.Unlock()
+	return rf.file.Close()
+}
+
+func (rf *RotatingFile) shouldRotate(incoming int64) bool {
+	if rf.size == 0 {
+		return false
+	}
+	if rf.maxBytes > 0 && rf.size+incoming > rf.maxBytes {
+		return true
+	}
+	return rf.maxAge > 0 && rf.now().Sub(rf.openedAt) >= rf.maxAge
+}
+
+func (rf *RotatingFile) open() error {
+	file, err := os.OpenFile(rf.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	rf.file = file
+	rf.size = info.Size()
+	rf.openedAt = rf.now()
+	return nil
+}
+
+// rotate renames the current file and only then swaps in a new one, so a
+// failed rotation leaves the sink writing to the file it already had. A file
+// removed from under us is simply recreated.
+func (rf *RotatingFile) rotate() error {
+	rotatedPath := rf.rotatedPath()
+	renamed := true
+	if err := os.Rename(rf.path, rotatedPath); os.IsNotExist(err) {
+		renamed = false
+	} else if err != nil {
+		return err
+	}
+
+	rotated := rf.file
+	if err := rf.open(); err != nil {
+		return err
+	}
+	if err := rotated.Close(); err != nil {
+		return err
+	}
+	if renamed && rf.compress {
+		return gzipFile(rotatedPath)
+	}
+	return nil
+}
+
+func (rf *RotatingFile) rotatedPath() string {
+	ext := filepath.Ext(rf.path)
+	base := strings.TrimSuffix(rf.path, ext) + "-" + rf.now().UTC().Format(ROTATED_TIME_FORMAT)
+	path := base + ext
+	for i := 1; exists(path) || exists(path+".gz"); i++ {
+		path = base + "-" + strconv.Itoa(i) + ext
+	}
+	return path
+}
+
+func exists(path string) bool {
+	_, err := os.Lstat(path)
+	return err == nil
+}
+
+func gzipFile(path string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(path+".gz", os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	gz := gzip.NewWriter(out)
+	if _, err := io.Copy(gz, in); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
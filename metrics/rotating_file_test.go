@@ -0,0 +1,128 @@
+package metrics_test
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/cloudfoundry-incubator/thoth/metrics"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("RotatingFile", func() {
+	var (
+		dir  string
+		path string
+	)
+
+	rotated := func(pattern string) []string {
+		matches, err := filepath.Glob(filepath.Join(dir, pattern))
+		Expect(err).NotTo(HaveOccurred())
+		return matches
+	}
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "rotating-file")
+		Expect(err).NotTo(HaveOccurred())
+		path = filepath.Join(dir, "results.jsonl")
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("rotates when the file would exceed the maximum size", func() {
+		file, err := NewRotatingFile(path, 10, 0, false)
+		Expect(err).NotTo(HaveOccurred())
+		defer file.Close()
+
+		_, err = file.Write([]byte("12345678\n"))
+		Expect(err).NotTo(HaveOccurred())
+		_, err = file.Write([]byte("abc\n"))
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(rotated("results-*.jsonl")).To(HaveLen(1))
+		contents, err := ioutil.ReadFile(rotated("results-*.jsonl")[0])
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(contents)).To(Equal("12345678\n"))
+
+		contents, err = ioutil.ReadFile(path)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(contents)).To(Equal("abc\n"))
+	})
+
+	It("rotates when the file is older than the maximum age", func() {
+		file, err := NewRotatingFile(path, 0, 10*time.Millisecond, false)
+		Expect(err).NotTo(HaveOccurred())
+		defer file.Close()
+
+		_, err = file.Write([]byte("first\n"))
+		Expect(err).NotTo(HaveOccurred())
+		_, err = file.Write([]byte("second\n"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(rotated("results-*.jsonl")).To(BeEmpty())
+
+		time.Sleep(20 * time.Millisecond)
+		_, err = file.Write([]byte("third\n"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(rotated("results-*.jsonl")).To(HaveLen(1))
+	})
+
+	It("gzips rotated files when asked to", func() {
+		file, err := NewRotatingFile(path, 10, 0, true)
+		Expect(err).NotTo(HaveOccurred())
+		defer file.Close()
+
+		_, err = file.Write([]byte("12345678\n"))
+		Expect(err).NotTo(HaveOccurred())
+		_, err = file.Write([]byte("abc\n"))
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(rotated("results-*.jsonl")).To(BeEmpty())
+		Expect(rotated("results-*.jsonl.gz")).To(HaveLen(1))
+
+		compressed, err := os.Open(rotated("results-*.jsonl.gz")[0])
+		Expect(err).NotTo(HaveOccurred())
+		defer compressed.Close()
+		reader, err := gzip.NewReader(compressed)
+		Expect(err).NotTo(HaveOccurred())
+		contents, err := ioutil.ReadAll(reader)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(contents)).To(Equal("12345678\n"))
+	})
+	It("keeps every rotated file when rotating repeatedly", func() {
+		file, err := NewRotatingFile(path, 1, 0, true)
+		Expect(err).NotTo(HaveOccurred())
+		defer file.Close()
+
+		for i := 0; i < 20; i++ {
+			_, err = file.Write([]byte("x\n"))
+			Expect(err).NotTo(HaveOccurred())
+		}
+		Expect(rotated("results-*.jsonl.gz")).To(HaveLen(19))
+	})
+
+	It("recreates the file when it was removed before rotating", func() {
+		file, err := NewRotatingFile(path, 10, 0, false)
+		Expect(err).NotTo(HaveOccurred())
+		defer file.Close()
+
+		_, err = file.Write([]byte("12345678\n"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(os.Remove(path)).To(Succeed())
+
+		_, err = file.Write([]byte("abc\n"))
+		Expect(err).NotTo(HaveOccurred())
+		_, err = file.Write([]byte("def\n"))
+		Expect(err).NotTo(HaveOccurred())
+
+		contents, err := ioutil.ReadFile(path)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(contents)).To(Equal("abc\ndef\n"))
+	})
+})
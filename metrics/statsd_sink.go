@@ -0,0 +1,157 @@
+package metrics
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudfoundry-incubator/thoth/benchmark"
+	"github.com/pivotal-golang/lager"
+)
+
+const (
+	STATSD_PREFIX     = "app_benchmarking."
+	STATSD_QUEUE_SIZE = 1024
+)
+
+type StatsdSink struct {
+	conn    net.Conn
+	tagged  bool
+	packets chan []byte
+	done    chan struct{}
+	logger  lager.Logger
+
+	mutex  sync.RWMutex
+	closed bool
+}
+
+func NewStatsdSink(address string, tagged bool, logger lager.Logger) (*StatsdSink, error) {
+	conn, err := net.Dial("udp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	ss := &StatsdSink{
+		conn:    conn,
+		tagged:  tagged,
+		packets: make(chan []byte, STATSD_QUEUE_SIZE),
+		done:    make(chan struct{}),
+		logger:  logger.Session("statsd"),
+	}
+	go ss.send()
+	return ss, nil
+}
+
+func (ss *StatsdSink) Emit(response benchmark.BenchmarkResponse, tags []string) error {
+	tags = append(response.Tags(), tags...)
+	lines := []string{}
+	for _, phase := range response.Phases() {
+		lines = append(lines, ss.line(phase.Name, timer(phase.Duration), tags))
+	}
+	lines = append(lines, ss.line("benchmarks", "1|c", tags))
+	if response.Outcome() != benchmark.OutcomeSuccess {
+		lines = append(lines, ss.line("failed_benchmarks", "1|c", tags))
+	}
+	return ss.enqueue(lines)
+}
+
+func (ss *StatsdSink) EmitFailure(err error, tags []string) error {
+	tags = append(benchmark.FailureTags(err), tags...)
+	lines := []string{
+		ss.line("benchmarks", "1|c", tags),
+		ss.line("failed_benchmarks", "1|c", tags),
+	}
+	if benchmark.IsTimeout(err) {
+		lines = append(lines, ss.line("timed_out_benchmarks", "1|c", tags))
+	}
+	return ss.enqueue(lines)
+}
+
+func (ss *StatsdSink) EmitSummary(summary Summary, tags []string) error {
+	lines := []string{}
+	for _, phase := range benchmark.PhaseNames {
+		phaseSummary, ok := summary.Phases[phase]
+		if !ok {
+			continue
+		}
+		lines = append(lines, ss.line(phase+".count", strconv.FormatInt(phaseSummary.Count, 10)+"|c", tags))
+		for _, stat := range phaseSummary.Stats() {
+			lines = append(lines, ss.line(phase+"."+stat.Name, gauge(stat.Value), tags))
+		}
+	}
+	return ss.enqueue(lines)
+}
+
+func (ss *StatsdSink) EmitDelivery(delivery benchmark.EnvelopeDelivery, tags []string) error {
+	tags = append(delivery.Tags(), tags...)
+	lines := []string{ss.line("envelopes", "1|c", tags)}
+	for _, latency := range delivery.Latencies() {
+		lines = append(lines, ss.line("log_delivery_latency", timer(latency.Duration), append(append([]string{}, tags...), "since:"+latency.Name)))
+	}
+	return ss.enqueue(lines)
+}
+
+func (ss *StatsdSink) Flush() error {
+	return nil
+}
+
+func (ss *StatsdSink) Close() error {
+	ss.mutex.Lock()
+	if ss.closed {
+		ss.mutex.Unlock()
+		return errors.New("statsd sink is already closed")
+	}
+	ss.closed = true
+	close(ss.packets)
+	ss.mutex.Unlock()
+
+	<-ss.done
+	return ss.conn.Close()
+}
+
+func (ss *StatsdSink) enqueue(lines []string) error {
+	ss.mutex.RLock()
+	defer ss.mutex.RUnlock()
+
+	if ss.closed {
+		return errors.New("statsd sink is closed, dropping metrics")
+	}
+	select {
+	case ss.packets <- []byte(strings.Join(lines, "\n")):
+		return nil
+	default:
+		return errors.New("statsd queue is full, dropping metrics")
+	}
+}
+
+func (ss *StatsdSink) send() {
+	defer close(ss.done)
+	for packet := range ss.packets {
+		if _, err := ss.conn.Write(packet); err != nil {
+			ss.logger.Error("cannot-emit-metric", err)
+		}
+	}
+}
+
+func (ss *StatsdSink) line(name, value string, tags []string) string {
+	line := STATSD_PREFIX + name + ":" + value
+	if ss.tagged && len(tags) > 0 {
+		line += "|#" + strings.Join(tags, ",")
+	}
+	return line
+}
+
+func timer(d time.Duration) string {
+	return milliseconds(d) + "|ms"
+}
+
+func gauge(d time.Duration) string {
+	return milliseconds(d) + "|g"
+}
+
+func milliseconds(d time.Duration) string {
+	return strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', -1, 64)
+}
@@ -0,0 +1,131 @@
+package metrics_test
+
+import (
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/cloudfoundry-incubator/thoth/benchmark"
+	. "github.com/cloudfoundry-incubator/thoth/metrics"
+	"github.com/cloudfoundry/sonde-go/events"
+	"github.com/pivotal-golang/lager/lagertest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("StatsdSink", func() {
+	var (
+		listener *net.UDPConn
+		response benchmark.BenchmarkResponse
+	)
+
+	receive := func() string {
+		buf := make([]byte, 4096)
+		listener.SetReadDeadline(time.Now().Add(time.Second))
+		n, err := listener.Read(buf)
+		Expect(err).NotTo(HaveOccurred())
+		return string(buf[:n])
+	}
+
+	BeforeEach(func() {
+		var err error
+		listener, err = net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+		Expect(err).NotTo(HaveOccurred())
+
+		response = benchmark.BenchmarkResponse{
+			ResponseCode:  http.StatusOK,
+			TotalRoundrip: 50 * time.Millisecond,
+			TimeInApp:     20 * time.Millisecond,
+			TimeInRouter:  10500 * time.Microsecond,
+			RestOfTime:    19500 * time.Microsecond,
+		}
+	})
+
+	AfterEach(func() {
+		listener.Close()
+	})
+
+	Context("with DogStatsD tags", func() {
+		It("sends tagged timers for every phase", func() {
+			sink, err := NewStatsdSink(listener.LocalAddr().String(), true, lagertest.NewTestLogger("test"))
+			Expect(err).NotTo(HaveOccurred())
+			defer sink.Close()
+
+			Expect(sink.Emit(response, []string{"deployment:cf", "index:0"})).To(Succeed())
+			Expect(receive()).To(Equal(
+				"app_benchmarking.total_roundtrip:50|ms|#status:200,outcome:success,deployment:cf,index:0\n" +
+					"app_benchmarking.corrected_roundtrip:50|ms|#status:200,outcome:success,deployment:cf,index:0\n" +
+					"app_benchmarking.time_in_gorouter:10.5|ms|#status:200,outcome:success,deployment:cf,index:0\n" +
+					"app_benchmarking.time_in_app:20|ms|#status:200,outcome:success,deployment:cf,index:0\n" +
+					"app_benchmarking.rest_of_time:19.5|ms|#status:200,outcome:success,deployment:cf,index:0\n" +
+					"app_benchmarking.dns_lookup:0|ms|#status:200,outcome:success,deployment:cf,index:0\n" +
+					"app_benchmarking.connect:0|ms|#status:200,outcome:success,deployment:cf,index:0\n" +
+					"app_benchmarking.tls_handshake:0|ms|#status:200,outcome:success,deployment:cf,index:0\n" +
+					"app_benchmarking.time_to_first_byte:0|ms|#status:200,outcome:success,deployment:cf,index:0\n" +
+					"app_benchmarking.body_read:0|ms|#status:200,outcome:success,deployment:cf,index:0\n" +
+					"app_benchmarking.benchmarks:1|c|#status:200,outcome:success,deployment:cf,index:0",
+			))
+		})
+
+		It("counts failures and timeouts", func() {
+			sink, err := NewStatsdSink(listener.LocalAddr().String(), true, lagertest.NewTestLogger("test"))
+			Expect(err).NotTo(HaveOccurred())
+			defer sink.Close()
+
+			Expect(sink.EmitFailure(&benchmark.MissingHttpStartStopError{PartialTiming: benchmark.PartialTiming{ResponseCode: http.StatusOK}}, []string{"index:0"})).To(Succeed())
+			Expect(receive()).To(Equal(
+				"app_benchmarking.benchmarks:1|c|#status:200,outcome:envelope_timeout,index:0\n" +
+					"app_benchmarking.failed_benchmarks:1|c|#status:200,outcome:envelope_timeout,index:0\n" +
+					"app_benchmarking.timed_out_benchmarks:1|c|#status:200,outcome:envelope_timeout,index:0",
+			))
+		})
+
+		It("counts correlated error responses as failures", func() {
+			sink, err := NewStatsdSink(listener.LocalAddr().String(), true, lagertest.NewTestLogger("test"))
+			Expect(err).NotTo(HaveOccurred())
+			defer sink.Close()
+
+			response.ResponseCode = http.StatusBadGateway
+			Expect(sink.Emit(response, []string{"index:0"})).To(Succeed())
+			Expect(receive()).To(HaveSuffix(
+				"app_benchmarking.benchmarks:1|c|#status:502,outcome:http_error,index:0\n" +
+					"app_benchmarking.failed_benchmarks:1|c|#status:502,outcome:http_error,index:0",
+			))
+		})
+
+		It("counts late envelopes and times their delivery latency", func() {
+			sink, err := NewStatsdSink(listener.LocalAddr().String(), true, lagertest.NewTestLogger("test"))
+			Expect(err).NotTo(HaveOccurred())
+			defer sink.Close()
+
+			delivery := benchmark.EnvelopeDelivery{EventType: events.Envelope_LogMessage, Status: benchmark.DeliveryLate, Gap: 2500 * time.Millisecond}
+			Expect(sink.EmitDelivery(delivery, []string{"index:0"})).To(Succeed())
+			Expect(receive()).To(Equal(
+				"app_benchmarking.envelopes:1|c|#delivery:late,event_type:log_message,index:0\n" +
+					"app_benchmarking.log_delivery_latency:2500|ms|#delivery:late,event_type:log_message,index:0,since:response",
+			))
+		})
+	})
+
+	It("returns an error instead of panicking when used after Close", func() {
+		sink, err := NewStatsdSink(listener.LocalAddr().String(), true, lagertest.NewTestLogger("test"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(sink.Close()).To(Succeed())
+
+		Expect(sink.Emit(response, nil)).To(HaveOccurred())
+		Expect(sink.EmitDelivery(benchmark.EnvelopeDelivery{Status: benchmark.DeliveryLost}, nil)).To(HaveOccurred())
+		Expect(sink.Close()).To(HaveOccurred())
+	})
+
+	Context("with plain StatsD", func() {
+		It("drops the tags", func() {
+			sink, err := NewStatsdSink(listener.LocalAddr().String(), false, lagertest.NewTestLogger("test"))
+			Expect(err).NotTo(HaveOccurred())
+			defer sink.Close()
+
+			Expect(sink.Emit(response, []string{"deployment:cf"})).To(Succeed())
+			Expect(receive()).To(HavePrefix("app_benchmarking.total_roundtrip:50|ms\n"))
+		})
+	})
+})
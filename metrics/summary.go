@@ -0,0 +1,63 @@
+package metrics
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/cloudfoundry-incubator/thoth/benchmark"
+)
+
+type PhaseSummary struct {
+	Count int64
+	Min   time.Duration
+	Max   time.Duration
+	Mean  time.Duration
+	P50   time.Duration
+	P90   time.Duration
+	P99   time.Duration
+	P999  time.Duration
+}
+
+func (ps PhaseSummary) Stats() []Stat {
+	return []Stat{
+		{"min", ps.Min},
+		{"max", ps.Max},
+		{"mean", ps.Mean},
+		{"p50", ps.P50},
+		{"p90", ps.P90},
+		{"p99", ps.P99},
+		{"p99_9", ps.P999},
+	}
+}
+
+type Stat struct {
+	Name  string
+	Value time.Duration
+}
+
+type Summary struct {
+	Timestamp time.Time
+	Window    time.Duration
+	Phases    map[string]PhaseSummary
+}
+
+type SummarySink interface {
+	EmitSummary(summary Summary, tags []string) error
+}
+
+func (s Summary) ToInflux(tags []string) []string {
+	lines := []string{}
+	for _, phase := range benchmark.PhaseNames {
+		summary, ok := s.Phases[phase]
+		if !ok {
+			continue
+		}
+
+		fields := []string{"count=" + strconv.FormatInt(summary.Count, 10) + "i"}
+		for _, stat := range summary.Stats() {
+			fields = append(fields, stat.Name+"="+strconv.FormatInt(stat.Value.Nanoseconds(), 10)+"i")
+		}
+		lines = append(lines, benchmark.InfluxLine(INFLUX_SUMMARY_MEASUREMENT, append([]string{"phase:" + phase}, tags...), fields, s.Timestamp))
+	}
+	return lines
+}
@@ -0,0 +1,43 @@
+package metrics_test
+
+import (
+	"time"
+
+	. "github.com/cloudfoundry-incubator/thoth/metrics"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Summary", func() {
+	var summary Summary
+
+	BeforeEach(func() {
+		summary = Summary{
+			Timestamp: time.Unix(123456789, 0),
+			Window:    time.Minute,
+			Phases: map[string]PhaseSummary{
+				"time_in_app": {
+					Count: 3,
+					Min:   time.Millisecond,
+					Max:   3 * time.Millisecond,
+					Mean:  2 * time.Millisecond,
+					P50:   2 * time.Millisecond,
+					P90:   3 * time.Millisecond,
+					P99:   3 * time.Millisecond,
+					P999:  3 * time.Millisecond,
+				},
+			},
+		}
+	})
+
+	Describe("ToInflux()", func() {
+		It("renders a line per phase", func() {
+			Expect(summary.ToInflux([]string{"index:0"})).To(Equal([]string{
+				"app_benchmarking_summary,phase=time_in_app,index=0 " +
+					"count=3i,min=1000000i,max=3000000i,mean=2000000i,p50=2000000i,p90=3000000i,p99=3000000i,p99_9=3000000i " +
+					"123456789000000000",
+			}))
+		})
+	})
+})